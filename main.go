@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/kukinsula/monitoring/metric"
@@ -16,12 +17,23 @@ func main() {
 		usage(err)
 	}
 
+	if config.ListMetrics {
+		listMetrics(os.Stdout)
+		os.Exit(0)
+	}
+
 	monitoring, err := NewMonitoring(config)
 	if err != nil {
 		usage(err)
 	}
 
 	err = monitoring.Start()
+
+	closeErr := monitoring.Close()
+	if err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 		os.Exit(2)
@@ -35,5 +47,21 @@ func usage(err error) {
 
 	fmt.Fprintf(os.Stderr, "usage: %s [OPTIONS]\n\n", os.Args[0])
 	flag.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\nmetrics (* enabled by default):\n")
+	listMetrics(os.Stderr)
+
 	os.Exit(1)
 }
+
+// listMetrics prints the registered collectors and their descriptions.
+func listMetrics(w io.Writer) {
+	for _, c := range metric.Collectors() {
+		enabled := " "
+		if c.Enabled {
+			enabled = "*"
+		}
+
+		fmt.Fprintf(w, "  %s %-8s %s\n", enabled, c.Name, c.Description)
+	}
+}
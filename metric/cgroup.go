@@ -0,0 +1,483 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupOutputFile  = "cgroup"
+	cgroupControllers = "cgroup.controllers"
+)
+
+// Cgroup reports the resources used by the cgroups (v2) of the unified
+// hierarchy: systemd slices, services and containers. A system without
+// cgroup v2 has no cgroup instead of failing.
+type Cgroup struct {
+	saver
+	config                 *Config
+	measures, lastMeasures map[string]*cgroupStats
+	time, lastTime         time.Time
+	unit                   rateUnit
+	include, exclude       []string
+}
+
+type cgroupStats struct {
+	Path      string                  `json:"-"`         // Relatif à la racine : system.slice/foo.service
+	CPU       float64                 `json:"cpu"`       // % d'un cœur
+	Throttled float64                 `json:"throttled"` // % des périodes où le quota était épuisé
+	Memory    uint64                  `json:"memory"`
+	MemoryMax int64                   `json:"memory-max"` // -1 sans limite
+	OOMKills  uint64                  `json:"oom-kills"`
+	Read      float64                 `json:"read"` // En méga-unités de -bits et -iec
+	Write     float64                 `json:"write"`
+	Pressure  map[string]*psiResource `json:"pressure"`
+	Container container               `json:"container"`
+	cpuStat   map[string]uint64
+	rbytes    uint64
+	wbytes    uint64
+}
+
+func init() {
+	mustRegister("cgroup", "CPU, memory, I/O and pressure per cgroup v2 (/sys/fs/cgroup)", false,
+		func(config *Config) (Metric, error) { return NewCgroup(config) })
+}
+
+func NewCgroup(config *Config) (*Cgroup, error) {
+	cgroup := &Cgroup{}
+
+	saver, err := newSaver(config, cgroup, cgroupOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cgroup.saver = *saver
+	cgroup.config = config
+	cgroup.measures = make(map[string]*cgroupStats)
+	cgroup.lastMeasures = make(map[string]*cgroupStats)
+	cgroup.unit = config.rateUnit()
+	cgroup.include = splitList(config.CgroupInclude)
+	cgroup.exclude = splitList(config.CgroupExclude)
+
+	return cgroup, nil
+}
+
+func (c *Cgroup) Update() error {
+	c.lastMeasures, c.lastTime = c.measures, c.time
+	c.measures = make(map[string]*cgroupStats)
+	c.time = c.config.now()
+
+	root, ok := c.root()
+	if !ok {
+		return nil
+	}
+
+	err := c.walk(root, "", 0)
+	if err != nil {
+		return err
+	}
+
+	c.computeRates()
+
+	return nil
+}
+
+// root returns the root of the unified hierarchy: /sys/fs/cgroup, or
+// /sys/fs/cgroup/unified on a hybrid system.
+func (c *Cgroup) root() (string, bool) {
+	for _, root := range []string{c.config.sysPath("fs", "cgroup"), c.config.sysPath("fs", "cgroup", "unified")} {
+		if _, err := c.config.readFile(path.Join(root, cgroupControllers)); err == nil {
+			return root, true
+		}
+	}
+
+	return "", false
+}
+
+// walk reads the cgroups below dir down to the configured depth. The
+// filters select the cgroups reported, not the ones walked through:
+// system.slice/* needs system.slice to be walked.
+func (c *Cgroup) walk(root, dir string, depth int) error {
+	if depth > 0 && isSelected(dir, c.include, c.exclude) {
+		err := c.read(root, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.config.CgroupDepth > 0 && depth >= c.config.CgroupDepth {
+		return nil
+	}
+
+	files, err := c.config.readDir(path.Join(root, dir))
+	if err != nil {
+		// Le cgroup a disparu entre-temps
+		return nil
+	}
+
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		err = c.walk(root, path.Join(dir, file.Name()), depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read reads the files of a cgroup. The files of the controllers not enabled
+// in the cgroup are missing.
+func (c *Cgroup) read(root, dir string) error {
+	g := &cgroupStats{Path: dir, MemoryMax: -1, Pressure: make(map[string]*psiResource),
+		Container: parseCgroupPath("/" + dir)}
+
+	file := func(name string) (string, bool) {
+		b, err := c.config.readFile(path.Join(root, dir, name))
+		if err != nil {
+			return "", false
+		}
+
+		return strings.TrimSpace(string(b)), true
+	}
+
+	var err error
+
+	if content, ok := file("cpu.stat"); ok {
+		g.cpuStat, err = parseKeyValues(content)
+		if err != nil {
+			return fmt.Errorf("invalid cpu.stat of cgroup %s: %s", dir, err)
+		}
+	}
+
+	if content, ok := file("memory.current"); ok {
+		g.Memory, err = strconv.ParseUint(content, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid memory.current of cgroup %s: %s", dir, err)
+		}
+	}
+
+	if content, ok := file("memory.max"); ok && content != "max" {
+		g.MemoryMax, err = strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid memory.max of cgroup %s: %s", dir, err)
+		}
+	}
+
+	if content, ok := file("memory.events"); ok {
+		events, err := parseKeyValues(content)
+		if err != nil {
+			return fmt.Errorf("invalid memory.events of cgroup %s: %s", dir, err)
+		}
+
+		g.OOMKills = events["oom_kill"]
+	}
+
+	if content, ok := file("io.stat"); ok {
+		err = g.parseIOStat(content)
+		if err != nil {
+			return fmt.Errorf("invalid io.stat of cgroup %s: %s", dir, err)
+		}
+	}
+
+	for _, name := range psiResources {
+		resource := &psiResource{Name: name}
+		g.Pressure[name] = resource
+
+		if content, ok := file(name + ".pressure"); ok {
+			err = resource.parse(content)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c.measures[dir] = g
+
+	return nil
+}
+
+// parseKeyValues parses the "key value" lines of cpu.stat or memory.events.
+func parseKeyValues(content string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		values[fields[0]] = value
+	}
+
+	return values, nil
+}
+
+// parseIOStat sums the bytes read and written on every device of io.stat:
+// "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0".
+func (g *cgroupStats) parseIOStat(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			i := strings.IndexByte(field, '=')
+			if i < 0 {
+				continue
+			}
+
+			var counter *uint64
+
+			switch field[:i] {
+			case "rbytes":
+				counter = &g.rbytes
+			case "wbytes":
+				counter = &g.wbytes
+			default:
+				continue
+			}
+
+			value, err := strconv.ParseUint(field[i+1:], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			*counter += value
+		}
+	}
+
+	return nil
+}
+
+// computeRates computes the CPU usage, the throttling, the I/O throughputs
+// and the pressure stalls of the cgroups seen at the last update too.
+func (c *Cgroup) computeRates() {
+	if c.lastTime.IsZero() {
+		return
+	}
+
+	elapsed := c.time.Sub(c.lastTime)
+	seconds := elapsed.Seconds()
+	us := float64(elapsed / time.Microsecond)
+
+	if seconds <= 0 {
+		return
+	}
+
+	for name, g := range c.measures {
+		last := c.lastMeasures[name]
+		if last == nil {
+			continue
+		}
+
+		if delta, ok := counterDelta(last.cpuStat["usage_usec"], g.cpuStat["usage_usec"], counter64); ok {
+			g.CPU = float64(delta) * 100.0 / us
+		}
+
+		periods, ok := counterDelta(last.cpuStat["nr_periods"], g.cpuStat["nr_periods"], counter64)
+		throttled, throttledOk := counterDelta(last.cpuStat["nr_throttled"], g.cpuStat["nr_throttled"], counter64)
+		if ok && throttledOk && periods > 0 {
+			g.Throttled = float64(throttled) * 100.0 / float64(periods)
+		}
+
+		if delta, ok := counterDelta(last.rbytes, g.rbytes, counter64); ok {
+			g.Read = c.unit.mega(float64(delta) / seconds)
+		}
+
+		if delta, ok := counterDelta(last.wbytes, g.wbytes, counter64); ok {
+			g.Write = c.unit.mega(float64(delta) / seconds)
+		}
+
+		for name, resource := range g.Pressure {
+			resource.computeStalls(last.Pressure[name], us)
+		}
+	}
+}
+
+// Cgroups returns the cgroups sorted by path.
+func (c *Cgroup) Cgroups() []*cgroupStats {
+	paths := make([]string, 0, len(c.measures))
+	for p := range c.measures {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	cgroups := make([]*cgroupStats, len(paths))
+	for i, p := range paths {
+		cgroups[i] = c.measures[p]
+	}
+
+	return cgroups
+}
+
+// PercentMemory returns the memory used by the cgroup in % of its limit, NaN
+// without limit.
+func (g *cgroupStats) PercentMemory() float64 {
+	if g.MemoryMax <= 0 {
+		return math.NaN()
+	}
+
+	return float64(g.Memory) * 100.0 / float64(g.MemoryMax)
+}
+
+// labels returns the Prometheus labels of a cgroup.
+func (g *cgroupStats) labels() []string {
+	return append([]string{"cgroup", g.Path}, g.Container.labels()...)
+}
+
+func (c *Cgroup) CSVHeader() []string {
+	var header []string
+
+	for _, g := range c.Cgroups() {
+		columns := []string{"cpu_pct", "throttled_pct", "memory_bytes", "memory_max_bytes", "oom_kills",
+			"read_" + c.unit.megaName(), "write_" + c.unit.megaName()}
+
+		for _, name := range psiResources {
+			columns = append(columns, name+"_some_stall_pct")
+		}
+
+		for _, column := range columns {
+			header = append(header, csvEscape(g.Path+"_"+column))
+		}
+	}
+
+	return header
+}
+
+func (c *Cgroup) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, g := range c.Cgroups() {
+		values = append(values, fmt.Sprintf("%.2f", g.CPU), fmt.Sprintf("%.2f", g.Throttled),
+			strconv.FormatUint(g.Memory, 10), strconv.FormatInt(g.MemoryMax, 10),
+			strconv.FormatUint(g.OOMKills, 10), fmt.Sprintf("%.3f", g.Read), fmt.Sprintf("%.3f", g.Write))
+
+		for _, name := range psiResources {
+			// Colonnes vides plutôt que des zéros trompeurs
+			if resource := g.Pressure[name]; resource.Available {
+				values = append(values, fmt.Sprintf("%.2f", resource.Some.Stall))
+			} else {
+				values = append(values, "")
+			}
+		}
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (c *Cgroup) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.measures)
+}
+
+func (c *Cgroup) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	cgroups := c.Cgroups()
+
+	counters := []struct {
+		name, help string
+		value      func(g *cgroupStats) float64
+	}{
+		{"cgroup_cpu_usage_seconds_total", "CPU time used by each cgroup.",
+			func(g *cgroupStats) float64 { return float64(g.cpuStat["usage_usec"]) / 1e6 }},
+		{"cgroup_cpu_periods_total", "Enforcement periods of the CPU quota of each cgroup.",
+			func(g *cgroupStats) float64 { return float64(g.cpuStat["nr_periods"]) }},
+		{"cgroup_cpu_throttled_periods_total", "Periods each cgroup exhausted its CPU quota.",
+			func(g *cgroupStats) float64 { return float64(g.cpuStat["nr_throttled"]) }},
+		{"cgroup_cpu_throttled_seconds_total", "Time each cgroup was throttled.",
+			func(g *cgroupStats) float64 { return float64(g.cpuStat["throttled_usec"]) / 1e6 }},
+		{"cgroup_oom_kills_total", "Processes of each cgroup killed by the OOM killer.",
+			func(g *cgroupStats) float64 { return float64(g.OOMKills) }},
+		{"cgroup_io_read_bytes_total", "Bytes read by each cgroup.",
+			func(g *cgroupStats) float64 { return float64(g.rbytes) }},
+		{"cgroup_io_written_bytes_total", "Bytes written by each cgroup.",
+			func(g *cgroupStats) float64 { return float64(g.wbytes) }},
+	}
+
+	for _, counter := range counters {
+		p.family(counter.name, prometheusCounter, counter.help)
+		for _, g := range cgroups {
+			p.sample(counter.value(g), g.labels()...)
+		}
+	}
+
+	p.family("cgroup_memory_bytes", prometheusGauge, "Memory used by each cgroup.")
+	for _, g := range cgroups {
+		p.sample(float64(g.Memory), g.labels()...)
+	}
+
+	p.family("cgroup_memory_max_bytes", prometheusGauge, "Memory limit of each cgroup, if any.")
+	for _, g := range cgroups {
+		if g.MemoryMax >= 0 {
+			p.sample(float64(g.MemoryMax), g.labels()...)
+		}
+	}
+
+	p.family("cgroup_pressure_stalled_seconds_total", prometheusCounter,
+		"Seconds some or all the tasks of each cgroup were stalled on each resource.")
+	for _, g := range cgroups {
+		for _, name := range psiResources {
+			resource := g.Pressure[name]
+			if !resource.Available {
+				continue
+			}
+
+			for i, s := range resource.stalls() {
+				if s != nil {
+					p.sample(float64(s.Total)/1e6, append(g.labels(), "resource", name, "kind", psiKinds[i])...)
+				}
+			}
+		}
+	}
+
+	return p.Bytes(), nil
+}
+
+func (c *Cgroup) String() string {
+	str := "\t========== CGROUP ==========\n\n"
+
+	if len(c.measures) == 0 {
+		return str + "No cgroup v2 selected"
+	}
+
+	unit := c.config.RateUnit()
+	str += "CPU %\tThrot %\tMemory\t\tMem %\tOOM\tr" + unit + "\tw" + unit + "\tPSI cpu/mem/io\tCgroup\n"
+
+	for _, g := range c.Cgroups() {
+		memory := "-"
+		if percent := g.PercentMemory(); !math.IsNaN(percent) {
+			memory = fmt.Sprintf("%.1f", percent)
+		}
+
+		var stalls []string
+		for _, name := range psiResources {
+			if resource := g.Pressure[name]; resource.Available {
+				stalls = append(stalls, fmt.Sprintf("%.0f", resource.Some.Stall))
+			} else {
+				stalls = append(stalls, "-")
+			}
+		}
+
+		str += fmt.Sprintf("%.2f\t%.2f\t%-10s\t%s\t%d\t%.3f\t%.3f\t%-14s\t%s\n",
+			g.CPU, g.Throttled, kbyte(g.Memory/1024), memory, g.OOMKills, g.Read, g.Write,
+			strings.Join(stalls, "/"), g.Path)
+	}
+
+	return str
+}
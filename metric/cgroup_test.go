@@ -0,0 +1,40 @@
+package metric
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCgroupPrometheusWithoutFullPressure(t *testing.T) {
+	cgroup, err := NewCgroup(&Config{SysRoot: "testdata/sys", Mode: ModeWEB, CgroupDepth: DefaultCgroupDepth})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cgroup.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := cgroup.MarshalPrometheus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := `cgroup="system.slice/nginx.service",container="",pod_uid="",unit="nginx.service"`
+
+	tests := []struct {
+		sample  string
+		present bool
+	}{
+		{`monitoring_cgroup_pressure_stalled_seconds_total{` + labels + `,resource="cpu",kind="some"} 0.3`, true},
+		{`resource="cpu",kind="full"`, false},
+		{`monitoring_cgroup_pressure_stalled_seconds_total{` + labels + `,resource="memory",kind="full"} 0.0005`, true},
+	}
+
+	for _, test := range tests {
+		if strings.Contains(string(b), test.sample) != test.present {
+			t.Errorf("sample %s present: %v, want %v in\n%s", test.sample, !test.present, test.present, b)
+		}
+	}
+}
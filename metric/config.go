@@ -3,15 +3,26 @@ package metric
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	DefaultDuration  = 0 // Infini
-	DefaultSleep     = 1
-	DefaultMetric    = "" // Tous par défaut
-	DefaultMode      = ModeCSV
-	DefaultOutputDir = "data/"
-	DefaultWebServer = ":8080"
+	DefaultDuration         = time.Duration(0) // Infini
+	DefaultSleep            = time.Second
+	DefaultMetric           = "" // Les collecteurs activés par défaut du registre
+	DefaultMode             = ModeCSV
+	DefaultOutputDir        = "data/"
+	DefaultWebServer        = ":8080"
+	DefaultPrometheusServer = "" // Désactivé par défaut
+	DefaultProcRoot         = "/proc"
+	DefaultSysRoot          = "/sys"
+	DefaultHostRoot         = "/"
+	DefaultProcSort         = SortByCPU
+	DefaultProcTop          = 20
+	DefaultCgroupDepth      = 2
 
 	DefaultConfig = &Config{
 		Duration:  DefaultDuration,
@@ -20,34 +31,112 @@ var (
 		Mode:      DefaultMode,
 		OutputDir: DefaultOutputDir,
 		WebServer: DefaultWebServer,
+
+		PrometheusServer: DefaultPrometheusServer,
+
+		ProcRoot: DefaultProcRoot,
+		SysRoot:  DefaultSysRoot,
+		HostRoot: DefaultHostRoot,
+
+		ProcessSort: DefaultProcSort,
+		ProcessTop:  DefaultProcTop,
+
+		CgroupDepth: DefaultCgroupDepth,
 	}
 )
 
 type Config struct {
-	Duration  int
-	Sleep     int
+	Duration  time.Duration
+	Sleep     time.Duration
 	Metrics   string
 	ModeStr   string // Mode : CSV, JSON, ...
 	Mode      Mode
 	OutputDir string
 	WebServer string
+
+	PrometheusServer string // Adresse du endpoint /metrics de Prometheus
+
+	MetricNames []string // Metrics sélectionnées par -metrics
+	ListMetrics bool
+
+	Record      string  // Archive où enregistrer les fichiers lus
+	Replay      string  // Archive à rejouer au lieu de lire le système
+	ReplaySpeed float64 // Vitesse de rejeu, 0 pour aller au plus vite
+
+	ProcRoot string // Racine du procfs lu par les collecteurs
+	SysRoot  string // Racine du sysfs lu par les collecteurs
+	HostRoot string // Racine du système de fichiers de l'hôte (points de montage)
+
+	ProcessSort string // pid, cpu ou mem
+	ProcessTop  int    // Nombre de processus affichés et sauvegardés
+
+	NetInclude string // Interfaces réseau suivies (motifs séparés par des virgules)
+	NetExclude string // Interfaces réseau ignorées (motifs séparés par des virgules)
+
+	CgroupDepth   int    // Profondeur maximale des cgroups suivis
+	CgroupInclude string // Cgroups suivis (motifs de chemins séparés par des virgules)
+	CgroupExclude string // Cgroups ignorés (motifs de chemins séparés par des virgules)
+
+	Bits bool // Débits en bits par seconde plutôt qu'en octets
+	IEC  bool // Débits en puissances de 1024 (KiB/s, MiB/s)
+
+	source source
 }
 
 func NewConfig() (*Config, error) {
+	var err error
+
 	config := DefaultConfig
 
-	flag.IntVar(&config.Duration, "duration", DefaultDuration,
-		"Monitoring duration in seconds (0 is infinite)")
-	flag.IntVar(&config.Sleep, "sleep", DefaultSleep,
-		"Update frequency in seconds")
+	config.Duration, config.Sleep = DefaultDuration, DefaultSleep
+
+	flag.Var(seconds{&config.Duration}, "duration",
+		"Monitoring duration in seconds or with a unit, 1h30m (0 is infinite)")
+	flag.Var(seconds{&config.Sleep}, "sleep",
+		"Update interval in seconds or with a unit, 500ms")
 	flag.StringVar(&config.Metrics, "metrics", DefaultMetric,
-		"Metrics to monitor: cpu,mem,proc,net (comma separated)")
+		"Metrics to monitor, comma separated: "+strings.Join(metricNames(), ",")+
+			" (see -list-metrics, default are the enabled ones)")
+	flag.BoolVar(&config.ListMetrics, "list-metrics", false,
+		"List the available metrics and exit")
 	flag.StringVar(&config.ModeStr, "mode", string(DefaultMode),
-		"Output mode: CSV, WEB")
+		"Output mode: csv, json, ndjson, web")
 	flag.StringVar(&config.OutputDir, "out-dir", DefaultOutputDir,
 		"Output files path")
 	flag.StringVar(&config.WebServer, "address", DefaultWebServer,
 		"Web server address")
+	flag.StringVar(&config.PrometheusServer, "prometheus-address", DefaultPrometheusServer,
+		"Prometheus /metrics endpoint address (disabled if empty)")
+	flag.StringVar(&config.Record, "record", "",
+		"Archive (tar.gz) where to record the raw files read at every update")
+	flag.StringVar(&config.Replay, "replay", "",
+		"Archive recorded with -record to replay instead of reading the system")
+	flag.Float64Var(&config.ReplaySpeed, "replay-speed", 1,
+		"Replay speed: 1 is the recorded pace, 2 twice faster, 0 as fast as possible")
+	flag.StringVar(&config.ProcRoot, "proc-root", DefaultProcRoot,
+		"Root of the proc filesystem, /host/proc in a container for instance")
+	flag.StringVar(&config.SysRoot, "sys-root", DefaultSysRoot,
+		"Root of the sys filesystem, /host/sys in a container for instance")
+	flag.StringVar(&config.HostRoot, "host-root", DefaultHostRoot,
+		"Root of the host filesystem, /host in a container for instance: the mounts of init are then reported, resolved under it, instead of the ones of the process")
+	flag.StringVar(&config.ProcessSort, "proc-sort", DefaultProcSort,
+		"Processes sort order: pid, cpu, mem")
+	flag.IntVar(&config.ProcessTop, "proc-top", DefaultProcTop,
+		"Number of processes displayed and saved (0 is all)")
+	flag.StringVar(&config.NetInclude, "net-include", "",
+		"Network interfaces to monitor, comma separated patterns: eth*,wlan0 (default all)")
+	flag.StringVar(&config.NetExclude, "net-exclude", "",
+		"Network interfaces to ignore, comma separated patterns: lo,veth*")
+	flag.IntVar(&config.CgroupDepth, "cgroup-depth", DefaultCgroupDepth,
+		"Depth of the cgroups monitored below the root, 2 is system.slice/foo.service (0 is all)")
+	flag.StringVar(&config.CgroupInclude, "cgroup-include", "",
+		"Cgroups to monitor, comma separated path patterns: system.slice/*,user.slice (default all)")
+	flag.StringVar(&config.CgroupExclude, "cgroup-exclude", "",
+		"Cgroups to ignore, comma separated path patterns: init.scope,*/*.mount")
+	flag.BoolVar(&config.Bits, "bits", false,
+		"Throughputs in bits per second instead of bytes")
+	flag.BoolVar(&config.IEC, "iec", false,
+		"Throughputs with binary prefixes (KiB/s, MiB/s) instead of decimal ones")
 
 	flag.Parse()
 
@@ -57,11 +146,143 @@ func NewConfig() (*Config, error) {
 		config.Mode = ModeCSV
 	case "json", "JSON", "js", "JS":
 		config.Mode = ModeJSON
+	case "ndjson", "NDJSON", "jsonl", "JSONL":
+		config.Mode = ModeNDJSON
 	case "web", "WEB", "Web":
 		config.Mode = ModeWEB
 	default:
 		return nil, fmt.Errorf("invalid mode '%s'", config.ModeStr)
 	}
 
+	config.MetricNames, err = ParseMetrics(config.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Record != "" && config.Replay != "" {
+		return nil, fmt.Errorf("-record and -replay are exclusive")
+	}
+
+	if config.Sleep <= 0 {
+		return nil, fmt.Errorf("invalid sleep '%s': must be positive", config.Sleep)
+	}
+
+	if config.Duration < 0 {
+		return nil, fmt.Errorf("invalid duration '%s': must be positive", config.Duration)
+	}
+
+	for _, pattern := range append(splitList(config.NetInclude), splitList(config.NetExclude)...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface pattern '%s'", pattern)
+		}
+	}
+
+	if config.CgroupDepth < 0 {
+		return nil, fmt.Errorf("invalid cgroup depth %d: must be positive", config.CgroupDepth)
+	}
+
+	for _, pattern := range append(splitList(config.CgroupInclude), splitList(config.CgroupExclude)...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cgroup pattern '%s'", pattern)
+		}
+	}
+
 	return config, nil
 }
+
+// procPath returns the path of a file of the proc filesystem, relative to
+// ProcRoot.
+func (c *Config) procPath(elem ...string) string {
+	root := c.ProcRoot
+	if root == "" {
+		root = DefaultProcRoot
+	}
+
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// sysPath returns the path of a file of the sys filesystem, relative to
+// SysRoot.
+func (c *Config) sysPath(elem ...string) string {
+	root := c.SysRoot
+	if root == "" {
+		root = DefaultSysRoot
+	}
+
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// hostPath returns the path of a file of the host filesystem, relative to
+// HostRoot.
+func (c *Config) hostPath(elem ...string) string {
+	root := c.HostRoot
+	if root == "" {
+		root = DefaultHostRoot
+	}
+
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// splitList splits a comma separated list, ignoring the empty elements.
+func splitList(list string) []string {
+	var elems []string
+
+	for _, elem := range strings.Split(list, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+
+	return elems
+}
+
+// isSelected reports whether a name is selected by the include and exclude
+// patterns (filepath.Match syntax). No include pattern selects all the names.
+func isSelected(name string, include, exclude []string) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(include) > 0 && !match(include) {
+		return false
+	}
+
+	return !match(exclude)
+}
+
+// seconds is a flag.Value parsing either a number of seconds (2, 0.5) or a
+// duration with a unit (500ms, 1m30s).
+type seconds struct {
+	duration *time.Duration
+}
+
+func (s seconds) String() string {
+	if s.duration == nil {
+		return ""
+	}
+
+	return s.duration.String()
+}
+
+func (s seconds) Set(str string) error {
+	f, err := strconv.ParseFloat(str, 64)
+	if err == nil {
+		*s.duration = time.Duration(f * float64(time.Second))
+		return nil
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s'", str)
+	}
+
+	*s.duration = d
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package metric
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Kubernetes QoS classes.
+const (
+	QoSGuaranteed = "guaranteed"
+	QoSBurstable  = "burstable"
+	QoSBestEffort = "besteffort"
+)
+
+var (
+	// Préfixes des scopes systemd des runtimes : docker-<id>.scope, ...
+	containerScopes = map[string]string{
+		"docker-":         "docker",
+		"cri-containerd-": "containerd",
+		"crio-":           "cri-o",
+		"libpod-":         "podman",
+	}
+
+	// Répertoires des runtimes avec le pilote cgroupfs : /docker/<id>, ...
+	containerDirs = map[string]string{
+		"docker":     "docker",
+		"containerd": "containerd",
+		"crio":       "cri-o",
+	}
+
+	containerID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	podUID      = regexp.MustCompile(`^pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})(\.slice)?$`)
+
+	// Suffixes des unités systemd qui regroupent des processus
+	unitSuffixes = []string{".service", ".scope"}
+)
+
+// container is the identity of a process or a cgroup derived from its cgroup
+// path: the container and the Kubernetes pod it belongs to, and its systemd
+// unit.
+type container struct {
+	Runtime string `json:"runtime,omitempty"` // docker, containerd, cri-o, podman, lxc
+	ID      string `json:"id,omitempty"`
+	PodUID  string `json:"pod-uid,omitempty"`
+	QoS     string `json:"qos,omitempty"`
+	Unit    string `json:"unit,omitempty"`
+	Cgroup  string `json:"cgroup,omitempty"`
+	MountNS string `json:"mount-ns,omitempty"` // Seulement s'il diffère de celui de l'hôte
+}
+
+// parseCgroupPath parses a cgroup path, with the systemd driver
+// (/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope)
+// or the cgroupfs one (/kubepods/burstable/pod<uid>/<id>, /docker/<id>).
+func parseCgroupPath(cgroupPath string) container {
+	c := container{Cgroup: cgroupPath}
+	elems := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+
+	for i, elem := range elems {
+		if m := podUID.FindStringSubmatch(lastDash(elem)); m != nil {
+			c.PodUID = strings.Replace(m[1], "_", "-", -1)
+		}
+
+		switch strings.TrimSuffix(elem, ".slice") {
+		case "kubepods":
+			// Les pods sans classe dans leur chemin sont garantis
+			if c.QoS == "" {
+				c.QoS = QoSGuaranteed
+			}
+		case "burstable", "kubepods-burstable":
+			c.QoS = QoSBurstable
+		case "besteffort", "kubepods-besteffort":
+			c.QoS = QoSBestEffort
+		}
+
+		for _, suffix := range unitSuffixes {
+			if strings.HasSuffix(elem, suffix) {
+				c.Unit = elem
+			}
+		}
+
+		if c.ID != "" {
+			continue
+		}
+
+		if id := strings.TrimSuffix(elem, ".scope"); id != elem {
+			for prefix, runtime := range containerScopes {
+				if strings.HasPrefix(id, prefix) && containerID.MatchString(id[len(prefix):]) {
+					c.Runtime, c.ID = runtime, id[len(prefix):]
+				}
+			}
+		} else if containerID.MatchString(elem) && i > 0 {
+			c.Runtime, c.ID = containerDirs[elems[i-1]], elem
+			if c.Runtime == "" && c.PodUID != "" {
+				c.Runtime = "kubernetes"
+			}
+		} else if strings.HasPrefix(elem, "lxc.payload.") {
+			c.Runtime, c.ID = "lxc", strings.TrimPrefix(elem, "lxc.payload.")
+		} else if elem == "lxc" && i+1 < len(elems) {
+			c.Runtime, c.ID = "lxc", elems[i+1]
+		}
+	}
+
+	if c.PodUID == "" {
+		c.QoS = ""
+	}
+
+	return c
+}
+
+// lastDash returns what follows the last dash of a systemd slice name:
+// kubepods-burstable-pod<uid>.slice gives pod<uid>.slice.
+func lastDash(elem string) string {
+	if !strings.HasSuffix(elem, ".slice") {
+		return elem
+	}
+
+	return elem[strings.LastIndexByte(elem, '-')+1:]
+}
+
+// parseProcCgroup parses /proc/<pid>/cgroup. The path of the unified
+// hierarchy ("0::/...") is preferred, then the one of systemd, then the
+// first one identifying a container in a v1 hierarchy.
+func parseProcCgroup(content string) container {
+	var unified, systemd string
+	var identified *container
+
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			unified = fields[2]
+		case fields[1] == "name=systemd":
+			systemd = fields[2]
+		case identified == nil:
+			if c := parseCgroupPath(fields[2]); c.ID != "" {
+				identified = &c
+			}
+		}
+	}
+
+	for _, p := range []string{unified, systemd} {
+		if p != "" && p != "/" {
+			c := parseCgroupPath(path.Clean(p))
+			if c.ID != "" || identified == nil {
+				return c
+			}
+		}
+	}
+
+	if identified != nil {
+		return *identified
+	}
+
+	return container{Cgroup: path.Clean("/" + unified + systemd)}
+}
+
+// Name returns the short name of the identity: the 12 first characters of the
+// container ID, else the systemd unit.
+func (c container) Name() string {
+	if len(c.ID) > 12 && containerID.MatchString(c.ID) {
+		return c.ID[:12]
+	}
+
+	if c.ID != "" {
+		return c.ID
+	}
+
+	return c.Unit
+}
+
+// labels returns the Prometheus labels of the identity.
+func (c container) labels() []string {
+	return []string{"container", c.ID, "pod_uid", c.PodUID, "unit", c.Unit}
+}
@@ -0,0 +1,107 @@
+package metric
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testContainerA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	testContainerB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	testContainerC = "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+)
+
+func TestParseCgroupPath(t *testing.T) {
+	tests := []struct {
+		path      string
+		container container
+	}{
+		{
+			"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1b2c3d4e_0000_1111_2222_333344445555.slice/cri-containerd-" + testContainerA + ".scope",
+			container{Runtime: "containerd", ID: testContainerA, PodUID: "1b2c3d4e-0000-1111-2222-333344445555",
+				QoS: QoSBurstable, Unit: "cri-containerd-" + testContainerA + ".scope"},
+		},
+		{
+			"/kubepods/besteffort/pod11112222-3333-4444-5555-666677778888/" + testContainerC,
+			container{Runtime: "kubernetes", ID: testContainerC, PodUID: "11112222-3333-4444-5555-666677778888",
+				QoS: QoSBestEffort},
+		},
+		{
+			"/kubepods/pod11112222-3333-4444-5555-666677778888/" + testContainerC,
+			container{Runtime: "kubernetes", ID: testContainerC, PodUID: "11112222-3333-4444-5555-666677778888",
+				QoS: QoSGuaranteed},
+		},
+		{
+			"/docker/" + testContainerB,
+			container{Runtime: "docker", ID: testContainerB},
+		},
+		{
+			"/system.slice/docker-" + testContainerB + ".scope",
+			container{Runtime: "docker", ID: testContainerB, Unit: "docker-" + testContainerB + ".scope"},
+		},
+		{
+			"/machine.slice/libpod-" + testContainerA + ".scope/container",
+			container{Runtime: "podman", ID: testContainerA, Unit: "libpod-" + testContainerA + ".scope"},
+		},
+		{"/lxc.payload.web01", container{Runtime: "lxc", ID: "web01"}},
+		{"/lxc/web01", container{Runtime: "lxc", ID: "web01"}},
+		{"/system.slice/nginx.service", container{Unit: "nginx.service"}},
+		{"/user.slice/user-1000.slice/session-3.scope", container{Unit: "session-3.scope"}},
+		{"/", container{}},
+	}
+
+	for _, test := range tests {
+		test.container.Cgroup = test.path
+
+		if c := parseCgroupPath(test.path); c != test.container {
+			t.Errorf("parseCgroupPath(%q) = %+v, want %+v", test.path, c, test.container)
+		}
+	}
+}
+
+func TestParseProcCgroup(t *testing.T) {
+	tests := []struct {
+		pid       string
+		container container
+	}{
+		{
+			// cgroup v2
+			"42",
+			container{Runtime: "containerd", ID: testContainerA, PodUID: "1b2c3d4e-0000-1111-2222-333344445555",
+				QoS: QoSBurstable, Unit: "cri-containerd-" + testContainerA + ".scope",
+				Cgroup: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1b2c3d4e_0000_1111_2222_333344445555.slice/cri-containerd-" + testContainerA + ".scope"},
+		},
+		{
+			// cgroup v1 : hiérarchie de systemd
+			"43",
+			container{Runtime: "docker", ID: testContainerB, Cgroup: "/docker/" + testContainerB},
+		},
+		{"44", container{Unit: "nginx.service", Cgroup: "/system.slice/nginx.service"}},
+		{
+			"45",
+			container{Runtime: "kubernetes", ID: testContainerC, PodUID: "11112222-3333-4444-5555-666677778888",
+				QoS: QoSGuaranteed, Cgroup: "/kubepods/pod11112222-3333-4444-5555-666677778888/" + testContainerC},
+		},
+		{
+			// cgroup v1 : seule une hiérarchie d'un contrôleur identifie le conteneur
+			"46",
+			container{Runtime: "lxc", ID: "web01", Cgroup: "/lxc/web01"},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "proc", test.pid, "cgroup"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c := parseProcCgroup(string(b)); c != test.container {
+			t.Errorf("parseProcCgroup(%s) = %+v, want %+v", test.pid, c, test.container)
+		}
+	}
+
+	if c := parseProcCgroup(""); c != (container{Cgroup: "/"}) {
+		t.Errorf("parseProcCgroup(\"\") = %+v, want the root cgroup", c)
+	}
+}
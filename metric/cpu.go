@@ -5,27 +5,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	stat          = "/proc/stat"
+	stat          = "stat"
 	cpuOutputFile = "cpu"
 	nbCpuColumns  = 10
 )
 
+// Colonnes des lignes cpu de /proc/stat
+const (
+	cpuIdle   = 3
+	cpuIowait = 4
+	cpuGuest  = 8
+)
+
+// cpuModes are the names of the columns of the cpu lines of /proc/stat.
+var cpuModes = [nbCpuColumns]string{
+	"user", "nice", "system", "idle", "iowait",
+	"irq", "softirq", "steal", "guest", "guest_nice",
+}
+
 type CPU struct {
 	saver
 	config                      *Config
 	currentMeasure, lastMeasure *cpuMeasure
 	LoadAverage                 float64
 	LoadAverages                []float64
+	Times                       CPUTimes
+	CoreTimes                   []CPUTimes
+	Cores, lastCores            []*cpuCore
 	NumCPU                      int
+	time, lastTime              time.Time
 }
 
+// CPUTimes is the percentage of time a CPU spent in each of the cpuModes
+// since the last measure.
+type CPUTimes [nbCpuColumns]float64
+
 type cpuMeasure struct {
 	NumberCpus   int   `json:"number"`
 	Ctxt         int   `json:"context"`
@@ -34,6 +55,12 @@ type cpuMeasure struct {
 	ProcsRunning int   `json:"procs-running"`
 	ProcsBlocked int   `json:"procs-blocked"`
 	cpus         [][nbCpuColumns]int
+	ids          []int // Numéro N des lignes cpuN, un CPU hors ligne en est absent
+}
+
+func init() {
+	mustRegister("cpu", "CPU usage, global and per core (/proc/stat)", true,
+		func(config *Config) (Metric, error) { return NewCPU(config) })
 }
 
 func NewCPU(config *Config) (*CPU, error) {
@@ -51,57 +78,221 @@ func NewCPU(config *Config) (*CPU, error) {
 	cpu.currentMeasure = newCpuMeasure()
 	cpu.lastMeasure = newCpuMeasure()
 	cpu.LoadAverages = make([]float64, NumCPU)
+	cpu.CoreTimes = make([]CPUTimes, NumCPU)
 
 	return cpu, nil
 }
 
 func (c *CPU) Update() error {
-	*c.lastMeasure = *c.currentMeasure
-	copy((*c.lastMeasure).cpus, (*c.currentMeasure).cpus)
-	c.currentMeasure.cpus = make([][nbCpuColumns]int, runtime.NumCPU()+1)
+	c.lastMeasure, c.currentMeasure = c.currentMeasure, c.lastMeasure
+	c.lastTime, c.time = c.time, c.config.now()
 
-	err := c.currentMeasure.update()
+	err := c.currentMeasure.update(c.config)
 	if err != nil {
 		return err
 	}
 
+	// Le nombre de CPUs est celui de /proc/stat, qui peut différer de
+	// runtime.NumCPU() (autre procfs, CPU branché à chaud)
+	if c.NumCPU != c.currentMeasure.NumberCpus {
+		c.NumCPU = c.currentMeasure.NumberCpus
+		c.LoadAverages = make([]float64, c.NumCPU)
+		c.CoreTimes = make([]CPUTimes, c.NumCPU)
+	}
+
 	c.computeCpuAverages()
+	c.updateCores()
 
 	return nil
 }
 
-// computeCpuAverages computes the global CPU and all CPU cores usage.
+// updateCores reads the frequency, the throttling and the idle states of
+// every CPU.
+func (c *CPU) updateCores() {
+	c.lastCores = c.Cores
+	c.Cores = make([]*cpuCore, c.NumCPU)
+
+	lastCores := make(map[int]*cpuCore, len(c.lastCores))
+	for _, core := range c.lastCores {
+		lastCores[core.id] = core
+	}
+
+	for i := range c.Cores {
+		c.Cores[i] = readCore(c.config, c.CoreID(i))
+
+		if !c.lastTime.IsZero() {
+			c.Cores[i].compute(lastCores[c.Cores[i].id], c.time.Sub(c.lastTime))
+		}
+	}
+}
+
+// CoreID returns the number of the i-th CPU of /proc/stat, which differs from
+// i when a CPU is offline.
+func (c *CPU) CoreID(i int) int {
+	if i < len(c.currentMeasure.ids) {
+		return c.currentMeasure.ids[i]
+	}
+
+	return i
+}
+
+// computeCpuAverages computes the global CPU and all CPU cores usage. A CPU
+// missing from the last measure is computed since boot.
 func (c *CPU) computeCpuAverages() {
-	c.LoadAverage = c.computeCpuLoad(c.currentMeasure.cpus[0], c.lastMeasure.cpus[0])
+	var zero [nbCpuColumns]int
+
+	last := zero
+	if len(c.lastMeasure.cpus) > 0 {
+		last = c.lastMeasure.cpus[0]
+	}
+
+	c.Times = computeCpuTimes(last, c.currentMeasure.cpus[0])
+	c.LoadAverage = c.Times.Busy()
+
+	// Les CPUs sont appariés par numéro : un CPU passé hors ligne décale les
+	// lignes suivantes
+	lastCpus := make(map[int][nbCpuColumns]int, len(c.lastMeasure.ids))
+	for i, id := range c.lastMeasure.ids {
+		lastCpus[id] = c.lastMeasure.cpus[i+1]
+	}
+
+	for i := 0; i < c.NumCPU; i++ {
+		c.CoreTimes[i] = computeCpuTimes(lastCpus[c.CoreID(i)], c.currentMeasure.cpus[i+1])
+		c.LoadAverages[i] = c.CoreTimes[i].Busy()
+	}
+}
+
+// computeCpuTimes computes the share of each mode between the CPU's first
+// and second raw CPU stats.
+func computeCpuTimes(first, second [nbCpuColumns]int) CPUTimes {
+	var times CPUTimes
+	var total float64
+
+	for mode := range times {
+		// Un compteur peut reculer (iowait sur certains noyaux) : ignoré
+		delta := second[mode] - first[mode]
+		if delta < 0 {
+			delta = 0
+		}
+
+		times[mode] = float64(delta)
+
+		// guest et guest_nice sont déjà comptés dans user et nice
+		if mode < cpuGuest {
+			total += times[mode]
+		}
+	}
+
+	if total == 0 {
+		return CPUTimes{}
+	}
+
+	for mode := range times {
+		times[mode] = times[mode] / total * 100.0
+	}
+
+	return times
+}
+
+// Busy returns the percentage of time the CPU was neither idle nor waiting
+// for I/O.
+func (t CPUTimes) Busy() float64 {
+	var total float64
+
+	for mode := range t {
+		if mode < cpuGuest {
+			total += t[mode]
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return math.Max(0, 100.0-t[cpuIdle]-t[cpuIowait])
+}
+
+func (t CPUTimes) MarshalJSON() ([]byte, error) {
+	m := make(map[string]float64, len(t))
 
-	for i := 0; i < runtime.NumCPU(); i++ {
-		c.LoadAverages[i] = c.computeCpuLoad(c.currentMeasure.cpus[i+1], c.lastMeasure.cpus[i+1])
+	for mode, percent := range t {
+		m[cpuModes[mode]] = percent
 	}
+
+	return json.Marshal(m)
+}
+
+func (t CPUTimes) String() string {
+	return fmt.Sprintf("us %5.1f  ni %5.1f  sy %5.1f  id %5.1f  wa %5.1f  hi %5.1f  si %5.1f  st %5.1f  gu %5.1f  gn %5.1f",
+		t[0], t[1], t[2], t[3], t[4], t[5], t[6], t[7], t[8], t[9])
 }
 
-// computeCpuLoad computes the CPU's first and second raw CPU stats.
-func (c *CPU) computeCpuLoad(first, second [nbCpuColumns]int) float64 {
-	numerator := float64((second[0] + second[1] + second[2]) -
-		(first[0] + first[1] + first[2]))
-	denominator := float64((second[0] + second[1] + second[2] + second[3]) -
-		(first[0] + first[1] + first[2] + first[3]))
+func (c *CPU) CSVHeader() []string {
+	header := []string{"cpu_load"}
+
+	for i := 0; i < c.NumCPU; i++ {
+		header = append(header, fmt.Sprintf("cpu%d_load", c.CoreID(i)))
+	}
+
+	for _, mode := range cpuModes {
+		header = append(header, "cpu_"+mode)
+	}
+
+	for i := 0; i < c.NumCPU; i++ {
+		for _, mode := range cpuModes {
+			header = append(header, fmt.Sprintf("cpu%d_%s", c.CoreID(i), mode))
+		}
+	}
+
+	frequency, states := hasFrequency(c.Cores), idleStateNames(c.Cores)
+
+	for i := range c.Cores {
+		if frequency {
+			header = append(header, fmt.Sprintf("cpu%d_mhz", c.CoreID(i)))
+		}
+
+		header = append(header, fmt.Sprintf("cpu%d_throttled", c.CoreID(i)))
+
+		for _, state := range states {
+			header = append(header, fmt.Sprintf("cpu%d_%s_pct", c.CoreID(i), strings.ToLower(state)))
+		}
+	}
 
-	return math.Abs(numerator / denominator * 100.0)
+	return header
 }
 
 func (c *CPU) MarshalCSV() ([]byte, error) {
-	str := fmt.Sprintf("%.2f,", c.LoadAverage)
+	values := []string{fmt.Sprintf("%.2f", c.LoadAverage)}
 
 	for i := 0; i < c.NumCPU; i++ {
-		str += fmt.Sprintf("%.2f", c.LoadAverages[i])
+		values = append(values, fmt.Sprintf("%.2f", c.LoadAverages[i]))
+	}
+
+	for _, percent := range c.Times {
+		values = append(values, fmt.Sprintf("%.2f", percent))
+	}
+
+	for i := 0; i < c.NumCPU; i++ {
+		for _, percent := range c.CoreTimes[i] {
+			values = append(values, fmt.Sprintf("%.2f", percent))
+		}
+	}
+
+	frequency, states := hasFrequency(c.Cores), idleStateNames(c.Cores)
 
-		if i != c.NumCPU-1 {
-			str += ","
+	for _, core := range c.Cores {
+		if frequency {
+			values = append(values, fmt.Sprintf("%.0f", core.Frequency))
+		}
+
+		values = append(values, strconv.FormatUint(core.Throttled, 10))
+
+		for _, state := range states {
+			values = append(values, fmt.Sprintf("%.2f", core.Idle[state]))
 		}
 	}
-	str += "\n"
 
-	return []byte(str), nil
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
 }
 
 func (c *CPU) MarshalJSON() ([]byte, error) {
@@ -113,17 +304,109 @@ func (c *CPU) MarshalJSON() ([]byte, error) {
 		"procs-blocked": c.currentMeasure.ProcsBlocked,
 		"load":          c.LoadAverage,
 		"loads":         c.LoadAverages,
+		"times":         c.Times,
+		"core-times":    c.CoreTimes,
+		"cores":         c.Cores,
 	}
 
 	return json.Marshal(m)
 }
 
+func (c *CPU) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	p.family("cpu_load_percent", prometheusGauge, "Usage of all the CPUs in percent.")
+	p.sample(c.LoadAverage)
+
+	p.family("cpu_core_load_percent", prometheusGauge, "Usage of each CPU in percent.")
+	for i := 0; i < c.NumCPU; i++ {
+		p.sample(c.LoadAverages[i], "cpu", strconv.Itoa(c.CoreID(i)))
+	}
+
+	p.family("cpu_seconds_total", prometheusCounter, "Seconds each CPU spent in each mode.")
+	for i := 0; i < c.NumCPU && i+1 < len(c.currentMeasure.cpus); i++ {
+		for mode, ticks := range c.currentMeasure.cpus[i+1] {
+			p.sample(float64(ticks)/userHZ, "cpu", strconv.Itoa(c.CoreID(i)), "mode", cpuModes[mode])
+		}
+	}
+
+	p.family("cpu_frequency_hertz", prometheusGauge, "Current frequency of each CPU.")
+	for i, core := range c.Cores {
+		if core.Frequency > 0 {
+			p.sample(core.Frequency*1e6, "cpu", strconv.Itoa(c.CoreID(i)))
+		}
+	}
+
+	p.family("cpu_frequency_min_hertz", prometheusGauge, "Minimum frequency of each CPU allowed by its governor.")
+	for i, core := range c.Cores {
+		if core.MinFrequency > 0 {
+			p.sample(core.MinFrequency*1e6, "cpu", strconv.Itoa(c.CoreID(i)))
+		}
+	}
+
+	p.family("cpu_frequency_max_hertz", prometheusGauge, "Maximum frequency of each CPU allowed by its governor.")
+	for i, core := range c.Cores {
+		if core.MaxFrequency > 0 {
+			p.sample(core.MaxFrequency*1e6, "cpu", strconv.Itoa(c.CoreID(i)))
+		}
+	}
+
+	p.family("cpu_scaling_governor", prometheusGauge, "Frequency governor of each CPU.")
+	for i, core := range c.Cores {
+		if core.Governor != "" {
+			p.sample(1, "cpu", strconv.Itoa(c.CoreID(i)), "governor", core.Governor)
+		}
+	}
+
+	p.family("cpu_core_throttles_total", prometheusCounter, "Times each CPU was throttled because its core was too hot.")
+	for i, core := range c.Cores {
+		p.sample(float64(core.CoreThrottles), "cpu", strconv.Itoa(c.CoreID(i)))
+	}
+
+	p.family("cpu_package_throttles_total", prometheusCounter, "Times each CPU was throttled because its package was too hot.")
+	for i, core := range c.Cores {
+		p.sample(float64(core.PackageThrottles), "cpu", strconv.Itoa(c.CoreID(i)))
+	}
+
+	p.family("cpu_idle_state_seconds_total", prometheusCounter, "Seconds each CPU spent in each idle state.")
+	for i, core := range c.Cores {
+		for _, state := range core.idleStates {
+			p.sample(float64(core.idleTimes[state])/1e6, "cpu", strconv.Itoa(c.CoreID(i)), "state", state)
+		}
+	}
+
+	p.family("context_switches_total", prometheusCounter, "Number of context switches.")
+	p.sample(float64(c.currentMeasure.Ctxt))
+
+	p.family("forks_total", prometheusCounter, "Number of processes created since boot.")
+	p.sample(float64(c.currentMeasure.Processes))
+
+	p.family("procs_running", prometheusGauge, "Number of runnable processes.")
+	p.sample(float64(c.currentMeasure.ProcsRunning))
+
+	p.family("procs_blocked", prometheusGauge, "Number of processes blocked waiting for I/O.")
+	p.sample(float64(c.currentMeasure.ProcsBlocked))
+
+	p.family("boot_time_seconds", prometheusGauge, "Boot time in seconds since the Epoch.")
+	p.sample(float64(c.currentMeasure.BootTime))
+
+	return p.Bytes(), nil
+}
+
 func (c *CPU) String() string {
 	str := "\t========== CPU ==========\n\n"
-	str += fmt.Sprintf("CPU: \t\t%.2f %%\n", c.LoadAverage)
+	str += fmt.Sprintf("CPU: \t\t%6.2f %%\t%s\n", c.LoadAverage, c.Times)
 
 	for i := 0; i < c.NumCPU; i++ {
-		str += fmt.Sprintf("CPU%d: \t\t%.2f %%\n", i, c.LoadAverages[i])
+		str += fmt.Sprintf("CPU%d: \t\t%6.2f %%\t%s", c.CoreID(i), c.LoadAverages[i], c.CoreTimes[i])
+
+		if i < len(c.Cores) {
+			if core := c.Cores[i].String(); core != "" {
+				str += "\t" + core
+			}
+		}
+
+		str += "\n"
 	}
 
 	str += fmt.Sprintf("\nCtxt: \t\t%d (%d)\n", c.currentMeasure.Ctxt, c.currentMeasure.Ctxt-c.lastMeasure.Ctxt)
@@ -146,30 +429,43 @@ func newCpuMeasure() *cpuMeasure {
 }
 
 // update uodates the cpuMeasure parsing /proc/stat file.
-func (c *cpuMeasure) update() error {
-	file, err := os.Open(stat)
+func (c *cpuMeasure) update(config *Config) error {
+	fileName := config.procPath(stat)
+
+	file, err := config.open(fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
 	var lineName string
-	var n, cpuCount int
+	var n int
+
+	c.cpus = c.cpus[:0]
+	c.ids = c.ids[:0]
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		if strings.Contains(line, "cpu") {
+		if strings.HasPrefix(line, "cpu") {
+			var cpu [nbCpuColumns]int
+
 			n, err = fmt.Sscanf(line, "%s %d %d %d %d %d %d %d %d %d %d", &lineName,
-				&c.cpus[cpuCount][0], &c.cpus[cpuCount][1],
-				&c.cpus[cpuCount][2], &c.cpus[cpuCount][3],
-				&c.cpus[cpuCount][4], &c.cpus[cpuCount][5],
-				&c.cpus[cpuCount][6], &c.cpus[cpuCount][7],
-				&c.cpus[cpuCount][8], &c.cpus[cpuCount][9],
+				&cpu[0], &cpu[1], &cpu[2], &cpu[3], &cpu[4],
+				&cpu[5], &cpu[6], &cpu[7], &cpu[8], &cpu[9],
 			)
 			checkSscanf(lineName, err, n, 11)
-			cpuCount++
+			c.cpus = append(c.cpus, cpu)
+
+			if lineName != "cpu" {
+				id, err := strconv.Atoi(strings.TrimPrefix(lineName, "cpu"))
+				if err != nil {
+					return fmt.Errorf("invalid %s line '%s'", fileName, lineName)
+				}
+
+				c.ids = append(c.ids, id)
+			}
 		} else if strings.Contains(line, "ctxt") {
 			n, err = fmt.Sscanf(line, "ctxt %d", &c.Ctxt)
 			checkSscanf("ctxt", err, n, 1)
@@ -188,6 +484,12 @@ func (c *cpuMeasure) update() error {
 		}
 	}
 
+	if len(c.cpus) == 0 {
+		return fmt.Errorf("no cpu in %s", fileName)
+	}
+
+	c.NumberCpus = len(c.cpus) - 1
+
 	return nil
 }
 
@@ -0,0 +1,191 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpuCore is the frequency, the thermal throttling and the idle states of a
+// CPU, from /sys/devices/system/cpu/cpu<N>. Virtual machines rarely expose
+// any of them.
+type cpuCore struct {
+	Frequency        float64            `json:"frequency,omitempty"` // MHz
+	MinFrequency     float64            `json:"min-frequency,omitempty"`
+	MaxFrequency     float64            `json:"max-frequency,omitempty"`
+	Governor         string             `json:"governor,omitempty"`
+	CoreThrottles    uint64             `json:"core-throttles"`    // Depuis le démarrage
+	PackageThrottles uint64             `json:"package-throttles"` // Depuis le démarrage
+	Throttled        uint64             `json:"throttled"`         // Depuis la dernière mesure
+	Idle             map[string]float64 `json:"idle,omitempty"`    // % du temps dans chaque C-state
+	idleStates       []string           // Dans l'ordre de cpuidle : POLL, C1, ...
+	idleTimes        map[string]uint64  // Microsecondes
+	id               int                // N de /sys/devices/system/cpu/cpuN
+}
+
+// cpuSysPath returns the path of a file of a CPU in the sys filesystem.
+func (c *Config) cpuSysPath(cpu int, elem ...string) string {
+	return c.sysPath(append([]string{"devices", "system", "cpu", "cpu" + strconv.Itoa(cpu)}, elem...)...)
+}
+
+// readCore reads the cpufreq, thermal_throttle and cpuidle files of a CPU.
+// The missing ones are left empty.
+func readCore(config *Config, cpu int) *cpuCore {
+	core := &cpuCore{Idle: make(map[string]float64), idleTimes: make(map[string]uint64), id: cpu}
+
+	value := func(elem ...string) (uint64, bool) {
+		b, err := config.readFile(config.cpuSysPath(cpu, elem...))
+		if err != nil {
+			return 0, false
+		}
+
+		v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+
+		return v, err == nil
+	}
+
+	// Fréquences en kHz ; cpuinfo_cur_freq est la fréquence matérielle, lisible
+	// seulement par root
+	if khz, ok := value("cpufreq", "scaling_cur_freq"); ok {
+		core.Frequency = float64(khz) / 1000
+	} else if khz, ok := value("cpufreq", "cpuinfo_cur_freq"); ok {
+		core.Frequency = float64(khz) / 1000
+	}
+
+	if khz, ok := value("cpufreq", "scaling_min_freq"); ok {
+		core.MinFrequency = float64(khz) / 1000
+	}
+
+	if khz, ok := value("cpufreq", "scaling_max_freq"); ok {
+		core.MaxFrequency = float64(khz) / 1000
+	}
+
+	if b, err := config.readFile(config.cpuSysPath(cpu, "cpufreq", "scaling_governor")); err == nil {
+		core.Governor = strings.TrimSpace(string(b))
+	}
+
+	core.CoreThrottles, _ = value("thermal_throttle", "core_throttle_count")
+	core.PackageThrottles, _ = value("thermal_throttle", "package_throttle_count")
+
+	states, err := config.readDir(config.cpuSysPath(cpu, "cpuidle"))
+	if err != nil {
+		return core
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(states[i].Name(), "state"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(states[j].Name(), "state"))
+		return a < b
+	})
+
+	for _, state := range states {
+		if !strings.HasPrefix(state.Name(), "state") {
+			continue
+		}
+
+		b, err := config.readFile(config.cpuSysPath(cpu, "cpuidle", state.Name(), "name"))
+		if err != nil {
+			continue
+		}
+
+		us, ok := value("cpuidle", state.Name(), "time")
+		if !ok {
+			continue
+		}
+
+		name := strings.TrimSpace(string(b))
+		core.idleStates = append(core.idleStates, name)
+		core.idleTimes[name] = us
+	}
+
+	return core
+}
+
+// compute computes the throttling and the idle states residency since
+// the last measure.
+func (core *cpuCore) compute(last *cpuCore, elapsed time.Duration) {
+	if last == nil || elapsed <= 0 {
+		return
+	}
+
+	coreDelta, coreOk := counterDelta(last.CoreThrottles, core.CoreThrottles, counter64)
+	packageDelta, packageOk := counterDelta(last.PackageThrottles, core.PackageThrottles, counter64)
+	if coreOk && packageOk {
+		core.Throttled = coreDelta + packageDelta
+	}
+
+	us := float64(elapsed / time.Microsecond)
+
+	for _, name := range core.idleStates {
+		lastTime, ok := last.idleTimes[name]
+		if !ok {
+			continue
+		}
+
+		if delta, ok := counterDelta(lastTime, core.idleTimes[name], counter64); ok {
+			core.Idle[name] = float64(delta) * 100.0 / us
+		}
+
+		if core.Idle[name] > 100.0 {
+			core.Idle[name] = 100.0
+		}
+	}
+}
+
+// String formats the frequency, the throttling and the idle states of a CPU
+// for the per-core lines of CPU.String.
+func (core *cpuCore) String() string {
+	var parts []string
+
+	if core.Frequency > 0 {
+		part := fmt.Sprintf("%4.0f MHz", core.Frequency)
+		if core.MaxFrequency > 0 {
+			part += fmt.Sprintf(" (%.0f-%.0f)", core.MinFrequency, core.MaxFrequency)
+		}
+		if core.Governor != "" {
+			part += " " + core.Governor
+		}
+
+		parts = append(parts, part)
+	}
+
+	if core.CoreThrottles > 0 || core.PackageThrottles > 0 {
+		parts = append(parts, fmt.Sprintf("throttled %d", core.Throttled))
+	}
+
+	if len(core.idleStates) > 0 {
+		var idle []string
+		for _, name := range core.idleStates {
+			idle = append(idle, fmt.Sprintf("%s %.1f", name, core.Idle[name]))
+		}
+
+		parts = append(parts, strings.Join(idle, " "))
+	}
+
+	return strings.Join(parts, "\t")
+}
+
+// idleStateNames returns the idle states of all the cores, in the order of
+// the first core exposing them.
+func idleStateNames(cores []*cpuCore) []string {
+	for _, core := range cores {
+		if len(core.idleStates) > 0 {
+			return core.idleStates
+		}
+	}
+
+	return nil
+}
+
+// hasFrequency reports whether any core exposes its frequency.
+func hasFrequency(cores []*cpuCore) bool {
+	for _, core := range cores {
+		if core.Frequency > 0 {
+			return true
+		}
+	}
+
+	return false
+}
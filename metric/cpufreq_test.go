@@ -0,0 +1,44 @@
+package metric
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadCore(t *testing.T) {
+	config := &Config{SysRoot: "testdata/sys"}
+
+	tests := []struct {
+		cpu  int
+		core *cpuCore
+	}{
+		{
+			0,
+			&cpuCore{
+				Frequency: 2400, MinFrequency: 800, MaxFrequency: 3600, Governor: "powersave",
+				CoreThrottles: 5, PackageThrottles: 1,
+				Idle: map[string]float64{},
+				// state10 après state2
+				idleStates: []string{"POLL", "C1", "C6", "C10"},
+				idleTimes:  map[string]uint64{"POLL": 100, "C1": 1000, "C6": 5500000, "C10": 20},
+				id:         0,
+			},
+		},
+		{
+			// Fréquence matérielle seulement, ni throttling ni cpuidle
+			2,
+			&cpuCore{Frequency: 1800, Idle: map[string]float64{}, idleTimes: map[string]uint64{}, id: 2},
+		},
+		{
+			// CPU absent de sysfs
+			5,
+			&cpuCore{Idle: map[string]float64{}, idleTimes: map[string]uint64{}, id: 5},
+		},
+	}
+
+	for _, test := range tests {
+		if core := readCore(config, test.cpu); !reflect.DeepEqual(core, test.core) {
+			t.Errorf("readCore(%d) = %+v, want %+v", test.cpu, core, test.core)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package metric
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	diskstats      = "diskstats"
+	diskOutputFile = "disk"
+	nbDiskColumns  = 11
+	sectorSize     = 512
+)
+
+// Index des colonnes de /proc/diskstats (après major, minor et nom)
+const (
+	diskReads = iota
+	diskReadsMerged
+	diskSectorsRead
+	diskMsReading
+	diskWrites
+	diskWritesMerged
+	diskSectorsWritten
+	diskMsWriting
+	diskIOsInProgress
+	diskMsDoingIO
+	diskWeightedMsDoingIO
+)
+
+// diskColumnBits returns the width of a column of /proc/diskstats: the times
+// are converted to milliseconds in 32 bits by jiffies_to_msecs.
+func diskColumnBits(column int) uint {
+	switch column {
+	case diskMsReading, diskMsWriting, diskMsDoingIO, diskWeightedMsDoingIO:
+		return counter32
+	}
+
+	return counter64
+}
+
+type Disk struct {
+	saver
+	config                 *Config
+	measures, lastMeasures map[string]*diskDevice
+	time, lastTime         time.Time
+	unit                   rateUnit
+}
+
+type diskDevice struct {
+	Name         string                `json:"-"`
+	ReadIOPS     float64               `json:"read-iops"`
+	WriteIOPS    float64               `json:"write-iops"`
+	Read         float64               `json:"read"` // En méga-unités de -bits et -iec
+	Write        float64               `json:"write"`
+	ReadLatency  float64               `json:"read-latency"`
+	WriteLatency float64               `json:"write-latency"`
+	QueueDepth   float64               `json:"queue-depth"`
+	Util         float64               `json:"util"`
+	Measure      [nbDiskColumns]uint64 `json:"-"`
+}
+
+func init() {
+	mustRegister("disk", "Disk I/O per device (/proc/diskstats)", false,
+		func(config *Config) (Metric, error) { return NewDisk(config) })
+}
+
+func NewDisk(config *Config) (*Disk, error) {
+	disk := &Disk{}
+
+	saver, err := newSaver(config, disk, diskOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	disk.saver = *saver
+	disk.config = config
+	disk.measures = make(map[string]*diskDevice)
+	disk.lastMeasures = make(map[string]*diskDevice)
+	disk.unit = config.rateUnit()
+
+	return disk, nil
+}
+
+func (d *Disk) Update() error {
+	d.lastMeasures, d.lastTime = d.measures, d.time
+	d.measures = make(map[string]*diskDevice)
+
+	file, err := d.config.open(d.config.procPath(diskstats))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	d.time = d.config.now()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < nbDiskColumns+3 {
+			continue
+		}
+
+		device := &diskDevice{Name: fields[2]}
+
+		for i := 0; i < nbDiskColumns; i++ {
+			device.Measure[i], err = strconv.ParseUint(fields[i+3], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s column %d: %s", diskstats, i+4, err)
+			}
+		}
+
+		// Les périphériques qui n'ont jamais servi (loop, ram, ...) ne sont
+		// que du bruit
+		if device.Measure[diskReads] == 0 && device.Measure[diskWrites] == 0 {
+			continue
+		}
+
+		d.measures[device.Name] = device
+	}
+
+	d.computeDiskStats()
+
+	return scanner.Err()
+}
+
+// computeDiskStats computes the rates of every device from the two last
+// samples, the same way iostat -x does. A device whose counters were reset
+// (detached and attached again) has no rates until the next sample.
+func (d *Disk) computeDiskStats() {
+	if d.lastTime.IsZero() {
+		return
+	}
+
+	elapsed := d.time.Sub(d.lastTime)
+	seconds := elapsed.Seconds()
+	ms := seconds * 1000
+
+	if seconds <= 0 {
+		return
+	}
+
+	for name, device := range d.measures {
+		last := d.lastMeasures[name]
+		if last == nil {
+			continue
+		}
+
+		var delta [nbDiskColumns]float64
+		reset := false
+
+		for column := range device.Measure {
+			increase, ok := counterDelta(last.Measure[column], device.Measure[column], diskColumnBits(column))
+			delta[column] = float64(increase)
+
+			// Seul diskIOsInProgress n'est pas un compteur
+			reset = reset || (!ok && column != diskIOsInProgress)
+		}
+
+		if reset {
+			continue
+		}
+
+		reads, writes := delta[diskReads], delta[diskWrites]
+
+		device.ReadIOPS = reads / seconds
+		device.WriteIOPS = writes / seconds
+		device.Read = d.unit.mega(delta[diskSectorsRead] * sectorSize / seconds)
+		device.Write = d.unit.mega(delta[diskSectorsWritten] * sectorSize / seconds)
+
+		if reads > 0 {
+			device.ReadLatency = delta[diskMsReading] / reads
+		}
+
+		if writes > 0 {
+			device.WriteLatency = delta[diskMsWriting] / writes
+		}
+
+		device.QueueDepth = delta[diskWeightedMsDoingIO] / ms
+		device.Util = delta[diskMsDoingIO] * 100.0 / ms
+
+		if device.Util > 100.0 {
+			device.Util = 100.0
+		}
+	}
+}
+
+// Devices returns the devices sorted by name.
+func (d *Disk) Devices() []*diskDevice {
+	names := make([]string, 0, len(d.measures))
+	for name := range d.measures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	devices := make([]*diskDevice, len(names))
+	for i, name := range names {
+		devices[i] = d.measures[name]
+	}
+
+	return devices
+}
+
+func (d *Disk) CSVHeader() []string {
+	var header []string
+
+	for _, v := range d.Devices() {
+		for _, column := range []string{"read_iops", "write_iops",
+			"read_" + d.unit.megaName(), "write_" + d.unit.megaName(),
+			"read_await_ms", "write_await_ms", "queue_depth", "util_pct"} {
+			header = append(header, v.Name+"_"+column)
+		}
+	}
+
+	return header
+}
+
+func (d *Disk) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, v := range d.Devices() {
+		values = append(values, fmt.Sprintf("%.2f", v.ReadIOPS), fmt.Sprintf("%.2f", v.WriteIOPS),
+			fmt.Sprintf("%.3f", v.Read), fmt.Sprintf("%.3f", v.Write),
+			fmt.Sprintf("%.2f", v.ReadLatency), fmt.Sprintf("%.2f", v.WriteLatency),
+			fmt.Sprintf("%.2f", v.QueueDepth), fmt.Sprintf("%.2f", v.Util))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (d *Disk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.measures)
+}
+
+func (d *Disk) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	counters := []struct {
+		name, help string
+		column     int
+		scale      float64
+	}{
+		{"disk_reads_completed_total", "Reads completed by each device.", diskReads, 1},
+		{"disk_read_bytes_total", "Bytes read by each device.", diskSectorsRead, sectorSize},
+		{"disk_read_time_seconds_total", "Seconds spent by all reads.", diskMsReading, 0.001},
+		{"disk_writes_completed_total", "Writes completed by each device.", diskWrites, 1},
+		{"disk_written_bytes_total", "Bytes written by each device.", diskSectorsWritten, sectorSize},
+		{"disk_write_time_seconds_total", "Seconds spent by all writes.", diskMsWriting, 0.001},
+		{"disk_io_time_seconds_total", "Seconds spent doing I/Os.", diskMsDoingIO, 0.001},
+		{"disk_io_time_weighted_seconds_total", "Weighted seconds spent doing I/Os.", diskWeightedMsDoingIO, 0.001},
+	}
+
+	devices := d.Devices()
+
+	for _, c := range counters {
+		p.family(c.name, prometheusCounter, c.help)
+		for _, v := range devices {
+			p.sample(float64(v.Measure[c.column])*c.scale, "device", v.Name)
+		}
+	}
+
+	p.family("disk_io_now", prometheusGauge, "I/Os currently in progress.")
+	for _, v := range devices {
+		p.sample(float64(v.Measure[diskIOsInProgress]), "device", v.Name)
+	}
+
+	return p.Bytes(), nil
+}
+
+func (d *Disk) String() string {
+	str := "\t========== DISK ==========\n\n"
+	unit := d.config.RateUnit()
+	str += "Device\t\tr/s\tw/s\tr" + unit + "\tw" + unit + "\tr_await\tw_await\taqu-sz\t%util\n"
+
+	for _, v := range d.Devices() {
+		str += fmt.Sprintf("%-8s\t%.2f\t%.2f\t%.3f\t%.3f\t%.2f\t%.2f\t%.2f\t%.2f\n",
+			v.Name, v.ReadIOPS, v.WriteIOPS, v.Read, v.Write,
+			v.ReadLatency, v.WriteLatency, v.QueueDepth, v.Util)
+	}
+
+	return str
+}
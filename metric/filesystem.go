@@ -0,0 +1,305 @@
+package metric
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	mountinfo     = "mountinfo"
+	hostMountinfo = "1/mountinfo"
+	selfMountinfo = "self/mountinfo"
+	fsOutputFile  = "fs"
+)
+
+// pseudoFilesystems are the filesystem types which do not store any data.
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true,
+	"cgroup2": true, "configfs": true, "debugfs": true, "devpts": true,
+	"devtmpfs": true, "efivarfs": true, "fusectl": true, "hugetlbfs": true,
+	"mqueue": true, "nsfs": true, "proc": true, "pstore": true,
+	"rpc_pipefs": true, "securityfs": true, "selinuxfs": true, "sysfs": true,
+	"tracefs": true,
+}
+
+type Filesystem struct {
+	saver
+	config *Config
+	mounts []*mountPoint
+}
+
+type mountPoint struct {
+	MountPoint string `json:"mount-point"`
+	Device     string `json:"device"`
+	Type       string `json:"type"`
+	ReadOnly   bool   `json:"read-only"`
+	Size       kbyte  `json:"size"`
+	Used       kbyte  `json:"used"`
+	Available  kbyte  `json:"available"`
+	Inodes     uint64 `json:"inodes"`
+	InodesUsed uint64 `json:"inodes-used"`
+	InodesFree uint64 `json:"inodes-free"`
+}
+
+func init() {
+	mustRegister("fs", "Usage of every mounted filesystem (mountinfo, statfs)", false,
+		func(config *Config) (Metric, error) { return NewFilesystem(config) })
+}
+
+func NewFilesystem(config *Config) (*Filesystem, error) {
+	fs := &Filesystem{}
+
+	saver, err := newSaver(config, fs, fsOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.saver = *saver
+	fs.config = config
+
+	return fs, nil
+}
+
+func (f *Filesystem) Update() error {
+	file, err := f.config.open(f.config.procPath(f.mountinfoFile()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Un point de montage peut être recouvert : seul le dernier compte
+	mounts := make(map[string]*mountPoint)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		mount, err := parseMountinfo(scanner.Text())
+		if err != nil {
+			return err
+		}
+
+		if pseudoFilesystems[mount.Type] {
+			delete(mounts, mount.MountPoint)
+			continue
+		}
+
+		mounts[mount.MountPoint] = mount
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	f.mounts = f.mounts[:0]
+
+	for _, mount := range mounts {
+		var stat syscall.Statfs_t
+
+		err = f.config.statfs(f.config.hostPath(mount.MountPoint), &stat)
+		if err != nil || stat.Blocks == 0 {
+			continue
+		}
+
+		blockSize := uint64(stat.Frsize)
+		if blockSize == 0 {
+			blockSize = uint64(stat.Bsize)
+		}
+
+		mount.Size = kbyte(stat.Blocks * blockSize / 1024)
+		mount.Used = kbyte((stat.Blocks - stat.Bfree) * blockSize / 1024)
+		mount.Available = kbyte(stat.Bavail * blockSize / 1024)
+		mount.Inodes = stat.Files
+		mount.InodesFree = stat.Ffree
+		mount.InodesUsed = stat.Files - stat.Ffree
+
+		f.mounts = append(f.mounts, mount)
+	}
+
+	sort.Sort(mountPoints(f.mounts))
+
+	return nil
+}
+
+// mountinfoFile returns the mountinfo file listing the mounts to report: the ones
+// of the process, unless -host-root points at the filesystem of the host, from
+// a container for instance. The mounts of init are then the ones of the host,
+// as long as ProcRoot is its procfs.
+func (f *Filesystem) mountinfoFile() string {
+	if root := f.config.HostRoot; root != "" && filepath.Clean(root) != DefaultHostRoot {
+		return hostMountinfo
+	}
+
+	return selfMountinfo
+}
+
+// parseMountinfo parses a line of /proc/self/mountinfo:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountinfo(line string) (*mountPoint, error) {
+	fields := strings.Fields(line)
+
+	separator := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			separator = i
+			break
+		}
+	}
+
+	if separator < 0 || len(fields) < separator+3 {
+		return nil, fmt.Errorf("invalid %s line '%s'", mountinfo, line)
+	}
+
+	readOnly := false
+	for _, option := range strings.Split(fields[5], ",") {
+		if option == "ro" {
+			readOnly = true
+		}
+	}
+
+	return &mountPoint{
+		MountPoint: unescapeMountinfo(fields[4]),
+		Device:     unescapeMountinfo(fields[separator+2]),
+		Type:       fields[separator+1],
+		ReadOnly:   readOnly,
+	}, nil
+}
+
+// unescapeMountinfo replaces the octal escapes (\040 for a space, ...) the
+// kernel uses in mountinfo.
+func unescapeMountinfo(str string) string {
+	if !strings.Contains(str, "\\") {
+		return str
+	}
+
+	var b []byte
+
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\\' && i+3 < len(str) {
+			c, err := strconv.ParseUint(str[i+1:i+4], 8, 8)
+			if err == nil {
+				b = append(b, byte(c))
+				i += 3
+				continue
+			}
+		}
+
+		b = append(b, str[i])
+	}
+
+	return string(b)
+}
+
+// PercentUsed returns the used space percentage the way df(1) does: the
+// space reserved to root is not considered available.
+func (m *mountPoint) PercentUsed() float64 {
+	total := m.Used + m.Available
+	if total == 0 {
+		return 0
+	}
+
+	return float64(m.Used) * 100.0 / float64(total)
+}
+
+func (m *mountPoint) PercentInodesUsed() float64 {
+	if m.Inodes == 0 {
+		return 0
+	}
+
+	return float64(m.InodesUsed) * 100.0 / float64(m.Inodes)
+}
+
+func (f *Filesystem) CSVHeader() []string {
+	var header []string
+
+	for _, m := range f.mounts {
+		for _, column := range []string{"size_kb", "used_kb", "available_kb", "used_pct",
+			"inodes", "inodes_used", "inodes_free", "read_only"} {
+			header = append(header, csvEscape(m.MountPoint+"_"+column))
+		}
+	}
+
+	return header
+}
+
+func (f *Filesystem) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, m := range f.mounts {
+		values = append(values, fmt.Sprintf("%d", m.Size), fmt.Sprintf("%d", m.Used),
+			fmt.Sprintf("%d", m.Available), fmt.Sprintf("%.2f", m.PercentUsed()),
+			fmt.Sprintf("%d", m.Inodes), fmt.Sprintf("%d", m.InodesUsed),
+			fmt.Sprintf("%d", m.InodesFree), fmt.Sprintf("%t", m.ReadOnly))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (f *Filesystem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.mounts)
+}
+
+func (f *Filesystem) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	gauges := []struct {
+		name, help string
+		value      func(m *mountPoint) float64
+	}{
+		{"filesystem_size_bytes", "Size of each filesystem.",
+			func(m *mountPoint) float64 { return float64(m.Size) * 1024 }},
+		{"filesystem_used_bytes", "Used space of each filesystem.",
+			func(m *mountPoint) float64 { return float64(m.Used) * 1024 }},
+		{"filesystem_avail_bytes", "Space available to non-root users.",
+			func(m *mountPoint) float64 { return float64(m.Available) * 1024 }},
+		{"filesystem_files", "Number of inodes of each filesystem.",
+			func(m *mountPoint) float64 { return float64(m.Inodes) }},
+		{"filesystem_files_free", "Number of free inodes of each filesystem.",
+			func(m *mountPoint) float64 { return float64(m.InodesFree) }},
+		{"filesystem_readonly", "Whether each filesystem is mounted read-only.",
+			func(m *mountPoint) float64 {
+				if m.ReadOnly {
+					return 1
+				}
+				return 0
+			}},
+	}
+
+	for _, g := range gauges {
+		p.family(g.name, prometheusGauge, g.help)
+		for _, m := range f.mounts {
+			p.sample(g.value(m), "mountpoint", m.MountPoint, "device", m.Device, "fstype", m.Type)
+		}
+	}
+
+	return p.Bytes(), nil
+}
+
+func (f *Filesystem) String() string {
+	str := "\t========== FILESYSTEMS ==========\n\n"
+	str += "Mount point\t\tType\tSize\t\tUsed\t\tAvail\t\tUse%\tIUse%\n"
+
+	for _, m := range f.mounts {
+		mode := ""
+		if m.ReadOnly {
+			mode = " (ro)"
+		}
+
+		str += fmt.Sprintf("%-16s\t%s\t%s\t%s\t%s\t%.1f %%\t%.1f %%%s\n",
+			m.MountPoint, m.Type, m.Size, m.Used, m.Available,
+			m.PercentUsed(), m.PercentInodesUsed(), mode)
+	}
+
+	return str
+}
+
+type mountPoints []*mountPoint
+
+func (m mountPoints) Len() int           { return len(m) }
+func (m mountPoints) Less(i, j int) bool { return m[i].MountPoint < m[j].MountPoint }
+func (m mountPoints) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
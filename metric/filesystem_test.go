@@ -0,0 +1,78 @@
+package metric
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMountinfo(t *testing.T) {
+	tests := []struct {
+		line  string
+		mount *mountPoint
+	}{
+		{
+			"36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue",
+			&mountPoint{MountPoint: "/mnt2", Device: "/dev/root", Type: "ext3"},
+		},
+		{
+			"25 1 259:2 / / ro,relatime shared:1 - ext4 /dev/nvme0n1p2 ro",
+			&mountPoint{MountPoint: "/", Device: "/dev/nvme0n1p2", Type: "ext4", ReadOnly: true},
+		},
+		{
+			// Sans champ optionnel
+			"98 25 0:45 / /run/user/1000 rw,nosuid,nodev - tmpfs tmpfs rw,size=1617128k",
+			&mountPoint{MountPoint: "/run/user/1000", Device: "tmpfs", Type: "tmpfs"},
+		},
+		{
+			// Plusieurs champs optionnels et des espaces échappés
+			`120 25 8:17 / /media/My\040Disk rw shared:60 master:2 - vfat /dev/sdb1 rw`,
+			&mountPoint{MountPoint: "/media/My Disk", Device: "/dev/sdb1", Type: "vfat"},
+		},
+		{
+			`121 25 0:50 / /mnt/back\134slash rw - fuse.sshfs user@host:/a\040b rw`,
+			&mountPoint{MountPoint: `/mnt/back\slash`, Device: "user@host:/a b", Type: "fuse.sshfs"},
+		},
+		{"36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 ext3 /dev/root rw", nil},
+		{"36 35 98:0 /mnt1 /mnt2 rw - ext3", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		mount, err := parseMountinfo(test.line)
+
+		if test.mount == nil {
+			if err == nil {
+				t.Errorf("parseMountinfo(%q) = %+v, want an error", test.line, mount)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseMountinfo(%q) failed: %s", test.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(mount, test.mount) {
+			t.Errorf("parseMountinfo(%q) = %+v, want %+v", test.line, mount, test.mount)
+		}
+	}
+}
+
+func TestFilesystemMountinfoFile(t *testing.T) {
+	tests := []struct {
+		hostRoot, file string
+	}{
+		{"", selfMountinfo},
+		{"/", selfMountinfo},
+		{"/host", hostMountinfo},
+		{"/host/", hostMountinfo},
+	}
+
+	for _, test := range tests {
+		f := &Filesystem{config: &Config{HostRoot: test.hostRoot}}
+
+		if file := f.mountinfoFile(); file != test.file {
+			t.Errorf("mountinfoFile() with -host-root %q = %s, want %s", test.hostRoot, file, test.file)
+		}
+	}
+}
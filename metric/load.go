@@ -0,0 +1,211 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	loadavg        = "loadavg"
+	uptime         = "uptime"
+	loadOutputFile = "load"
+)
+
+// Load is the load of the scheduler: the load averages of the kernel, which
+// count the runnable and the uninterruptible tasks, unlike CPU.LoadAverage.
+type Load struct {
+	saver
+	config                      *Config
+	currentMeasure, lastMeasure *loadMeasure
+	ContextSwitches             float64 // Par seconde
+	Forks                       float64 // Par seconde
+}
+
+type loadMeasure struct {
+	Load1    float64       `json:"load1"`
+	Load5    float64       `json:"load5"`
+	Load15   float64       `json:"load15"`
+	Runnable int           `json:"runnable"`
+	Entities int           `json:"entities"`
+	LastPid  int           `json:"last-pid"`
+	Uptime   time.Duration `json:"-"`
+	Idle     time.Duration `json:"-"`
+	stat     *cpuMeasure
+	time     time.Time
+}
+
+func init() {
+	mustRegister("load", "Load averages, uptime and scheduler activity (/proc/loadavg, /proc/uptime)", false,
+		func(config *Config) (Metric, error) { return NewLoad(config) })
+}
+
+func NewLoad(config *Config) (*Load, error) {
+	load := &Load{}
+
+	saver, err := newSaver(config, load, loadOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	load.saver = *saver
+	load.config = config
+	load.currentMeasure = &loadMeasure{stat: newCpuMeasure()}
+	load.lastMeasure = &loadMeasure{stat: newCpuMeasure()}
+
+	return load, nil
+}
+
+func (l *Load) Update() error {
+	l.lastMeasure, l.currentMeasure = l.currentMeasure, l.lastMeasure
+
+	err := l.currentMeasure.update(l.config)
+	if err != nil {
+		return err
+	}
+
+	l.computeRates()
+
+	return nil
+}
+
+// computeRates computes the context switches and the forks per second from
+// the counters of /proc/stat.
+func (l *Load) computeRates() {
+	l.ContextSwitches, l.Forks = 0, 0
+
+	if l.lastMeasure.time.IsZero() {
+		return
+	}
+
+	seconds := l.currentMeasure.time.Sub(l.lastMeasure.time).Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	// Les compteurs repartent de zéro au redémarrage (archive rejouée, ...)
+	ctxt, ok := counterDelta(uint64(l.lastMeasure.stat.Ctxt), uint64(l.currentMeasure.stat.Ctxt), counter64)
+	if ok {
+		l.ContextSwitches = float64(ctxt) / seconds
+	}
+
+	forks, ok := counterDelta(uint64(l.lastMeasure.stat.Processes), uint64(l.currentMeasure.stat.Processes), counter64)
+	if ok {
+		l.Forks = float64(forks) / seconds
+	}
+}
+
+func (l *Load) CSVHeader() []string {
+	return []string{
+		"load1", "load5", "load15", "runnable", "entities", "last_pid",
+		"uptime_s", "idle_s", "ctxt_per_s", "forks_per_s",
+	}
+}
+
+func (l *Load) MarshalCSV() ([]byte, error) {
+	m := l.currentMeasure
+
+	values := []string{
+		fmt.Sprintf("%.2f", m.Load1), fmt.Sprintf("%.2f", m.Load5), fmt.Sprintf("%.2f", m.Load15),
+		fmt.Sprintf("%d", m.Runnable), fmt.Sprintf("%d", m.Entities), fmt.Sprintf("%d", m.LastPid),
+		fmt.Sprintf("%.2f", m.Uptime.Seconds()), fmt.Sprintf("%.2f", m.Idle.Seconds()),
+		fmt.Sprintf("%.2f", l.ContextSwitches), fmt.Sprintf("%.2f", l.Forks),
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (l *Load) MarshalJSON() ([]byte, error) {
+	m := l.currentMeasure
+
+	return json.Marshal(map[string]interface{}{
+		"load1":            m.Load1,
+		"load5":            m.Load5,
+		"load15":           m.Load15,
+		"runnable":         m.Runnable,
+		"entities":         m.Entities,
+		"last-pid":         m.LastPid,
+		"uptime":           m.Uptime.Seconds(),
+		"idle":             m.Idle.Seconds(),
+		"context-switches": l.ContextSwitches,
+		"forks":            l.Forks,
+	})
+}
+
+func (l *Load) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	m := l.currentMeasure
+
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"load1", "Load average over 1 minute.", m.Load1},
+		{"load5", "Load average over 5 minutes.", m.Load5},
+		{"load15", "Load average over 15 minutes.", m.Load15},
+		{"sched_runnable", "Runnable scheduling entities.", float64(m.Runnable)},
+		{"sched_entities", "Scheduling entities (processes and threads).", float64(m.Entities)},
+		{"last_pid", "Last PID allocated.", float64(m.LastPid)},
+		{"uptime_seconds", "Seconds since boot.", m.Uptime.Seconds()},
+	}
+
+	for _, g := range gauges {
+		p.family(g.name, prometheusGauge, g.help)
+		p.sample(g.value)
+	}
+
+	p.family("idle_seconds_total", prometheusCounter, "Seconds spent idle by all the CPUs.")
+	p.sample(m.Idle.Seconds())
+
+	return p.Bytes(), nil
+}
+
+func (l *Load) String() string {
+	m := l.currentMeasure
+
+	str := "\t========== LOAD ==========\n\n"
+	str += fmt.Sprintf("Load: \t\t%.2f %.2f %.2f\n", m.Load1, m.Load5, m.Load15)
+	str += fmt.Sprintf("Tasks: \t\t%d/%d (last pid %d)\n", m.Runnable, m.Entities, m.LastPid)
+	str += fmt.Sprintf("Uptime: \t%v (idle %v)\n", m.Uptime.Round(time.Second), m.Idle.Round(time.Second))
+	str += fmt.Sprintf("Ctxt: \t\t%.0f /s\n", l.ContextSwitches)
+	str += fmt.Sprintf("Forks: \t\t%.2f /s", l.Forks)
+
+	return str
+}
+
+// update parses /proc/loadavg, /proc/uptime and the counters of /proc/stat.
+func (m *loadMeasure) update(config *Config) error {
+	b, err := config.readFile(config.procPath(loadavg))
+	if err != nil {
+		return err
+	}
+
+	n, err := fmt.Sscanf(string(b), "%f %f %f %d/%d %d",
+		&m.Load1, &m.Load5, &m.Load15, &m.Runnable, &m.Entities, &m.LastPid)
+	err = checkSscanf(loadavg, err, n, 6)
+	if err != nil {
+		return err
+	}
+
+	b, err = config.readFile(config.procPath(uptime))
+	if err != nil {
+		return err
+	}
+
+	var up, idle float64
+
+	n, err = fmt.Sscanf(string(b), "%f %f", &up, &idle)
+	err = checkSscanf(uptime, err, n, 2)
+	if err != nil {
+		return err
+	}
+
+	m.Uptime = time.Duration(up * float64(time.Second))
+	m.Idle = time.Duration(idle * float64(time.Second))
+
+	m.time = config.now()
+
+	return m.stat.update(config)
+}
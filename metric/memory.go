@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"os"
+	"strconv"
 	"strings"
 )
 
 const (
-	meminfo       = "/proc/meminfo"
+	meminfo       = "meminfo"
 	memOutputFile = "mem"
 )
 
@@ -19,13 +19,17 @@ type Memory struct {
 	config                      *Config
 	currentMeasure, lastMeasure *memoryMeasure
 
-	// TODO: ajouter DeltaMemFree, DeltaMemOccupied, DeltaSwapFree, ...
+	// Deltas are the variations of every field of /proc/meminfo since the
+	// last measure.
+	Deltas map[string]int64
 }
 
 type memoryMeasure struct {
 	MemTotal        kbyte `json:"total"`
 	MemFree         kbyte `json:"free"`
 	MemOccupied     kbyte `json:"occupied"`
+	MemUsed         kbyte `json:"used"`
+	MemBuffCache    kbyte `json:"buff-cache"`
 	MemAvailable    kbyte `json:"available"`
 	SwapTotal       kbyte `json:"swap-total"`
 	SwapFree        kbyte `json:"swap-free"`
@@ -33,6 +37,24 @@ type memoryMeasure struct {
 	VmallocTotal    kbyte `json:"vm-allocated-total"`
 	VmallocFree     kbyte `json:"vm-allocated-free"`
 	VmallocOccupied kbyte `json:"vm-allocated-occupied"`
+
+	// Meminfo holds all the fields of /proc/meminfo, in kB except the ones
+	// without unit (HugePages_Total, ...) which are counts.
+	Meminfo map[string]int64 `json:"meminfo"`
+	names   []string         // Champs dans l'ordre du fichier
+	counts  map[string]bool  // Champs sans unité
+}
+
+// memoryFields are the fields of /proc/meminfo shown by String.
+var memoryFields = []string{
+	"Buffers", "Cached", "SReclaimable", "Shmem", "Slab", "Dirty", "Writeback",
+	"AnonPages", "Mapped", "Committed_AS", "CommitLimit",
+	"HugePages_Total", "HugePages_Free", "HugePages_Rsvd", "HugePages_Surp",
+}
+
+func init() {
+	mustRegister("mem", "Memory and swap usage (/proc/meminfo)", true,
+		func(config *Config) (Metric, error) { return NewMemory(config) })
 }
 
 func NewMemory(config *Config) (*Memory, error) {
@@ -52,17 +74,121 @@ func NewMemory(config *Config) (*Memory, error) {
 }
 
 func (m *Memory) Update() error {
-	*m.lastMeasure = *m.currentMeasure
+	m.lastMeasure, m.currentMeasure = m.currentMeasure, m.lastMeasure
+
+	err := m.currentMeasure.update(m.config)
+	if err != nil {
+		return err
+	}
+
+	m.computeDeltas()
 
-	return m.currentMeasure.update()
+	return nil
+}
+
+// computeDeltas computes the variation of every field since the last
+// measure. A field missing from the last measure has no delta.
+func (m *Memory) computeDeltas() {
+	m.Deltas = make(map[string]int64, len(m.currentMeasure.Meminfo))
+
+	if m.lastMeasure.Meminfo == nil {
+		return
+	}
+
+	for name, value := range m.currentMeasure.Meminfo {
+		if last, ok := m.lastMeasure.Meminfo[name]; ok {
+			m.Deltas[name] = value - last
+		}
+	}
+}
+
+func (m *Memory) CSVHeader() []string {
+	header := []string{
+		"mem_total_kb", "mem_free_kb", "mem_occupied_kb", "mem_available_kb",
+		"swap_total_kb", "swap_free_kb", "swap_occupied_kb",
+		"mem_used_kb", "mem_buff_cache_kb",
+	}
+
+	for _, name := range m.currentMeasure.names {
+		header = append(header, m.currentMeasure.csvColumn(name))
+	}
+
+	for _, name := range m.currentMeasure.names {
+		header = append(header, m.currentMeasure.csvColumn(name)+"_delta")
+	}
+
+	return header
 }
 
 func (m *Memory) MarshalCSV() ([]byte, error) {
-	return m.currentMeasure.MarshalCSV()
+	c := m.currentMeasure
+
+	values := []string{
+		strconv.Itoa(int(c.MemTotal)), strconv.Itoa(int(c.MemFree)),
+		strconv.Itoa(int(c.MemOccupied)), strconv.Itoa(int(c.MemAvailable)),
+		strconv.Itoa(int(c.SwapTotal)), strconv.Itoa(int(c.SwapFree)),
+		strconv.Itoa(int(c.SwapOccupied)),
+		strconv.Itoa(int(c.MemUsed)), strconv.Itoa(int(c.MemBuffCache)),
+	}
+
+	for _, name := range c.names {
+		values = append(values, strconv.FormatInt(c.Meminfo[name], 10))
+	}
+
+	for _, name := range c.names {
+		values = append(values, strconv.FormatInt(m.Deltas[name], 10))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
 }
 
 func (m *Memory) MarshalJSON() ([]byte, error) {
-	return json.Marshal(m.currentMeasure)
+	return json.Marshal(struct {
+		*memoryMeasure
+		Deltas map[string]int64 `json:"deltas"`
+	}{m.currentMeasure, m.Deltas})
+}
+
+func (m *Memory) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	gauges := []struct {
+		name, help string
+		value      kbyte
+	}{
+		{"memory_total_bytes", "Total usable memory.", m.currentMeasure.MemTotal},
+		{"memory_free_bytes", "Free memory.", m.currentMeasure.MemFree},
+		{"memory_occupied_bytes", "Occupied memory.", m.currentMeasure.MemOccupied},
+		{"memory_available_bytes", "Memory available for starting new applications.", m.currentMeasure.MemAvailable},
+		{"swap_total_bytes", "Total swap space.", m.currentMeasure.SwapTotal},
+		{"swap_free_bytes", "Free swap space.", m.currentMeasure.SwapFree},
+		{"swap_occupied_bytes", "Occupied swap space.", m.currentMeasure.SwapOccupied},
+		{"vmalloc_total_bytes", "Total vmalloc address space.", m.currentMeasure.VmallocTotal},
+		{"vmalloc_occupied_bytes", "Occupied vmalloc address space.", m.currentMeasure.VmallocOccupied},
+		{"memory_used_bytes", "Memory used, excluding the buffers and the caches.", m.currentMeasure.MemUsed},
+		{"memory_buff_cache_bytes", "Memory used by the buffers and the caches.", m.currentMeasure.MemBuffCache},
+	}
+
+	for _, g := range gauges {
+		p.family(g.name, prometheusGauge, g.help)
+		p.sample(float64(g.value) * 1024)
+	}
+
+	p.family("meminfo_bytes", prometheusGauge, "Fields of /proc/meminfo in bytes.")
+	for _, name := range m.currentMeasure.names {
+		if !m.currentMeasure.counts[name] {
+			p.sample(float64(m.currentMeasure.Meminfo[name])*1024, "field", name)
+		}
+	}
+
+	p.family("meminfo_count", prometheusGauge, "Fields of /proc/meminfo without unit.")
+	for _, name := range m.currentMeasure.names {
+		if m.currentMeasure.counts[name] {
+			p.sample(float64(m.currentMeasure.Meminfo[name]), "field", name)
+		}
+	}
+
+	return p.Bytes(), nil
 }
 
 func (m *Memory) PercentMemFree() float64 {
@@ -74,6 +200,20 @@ func (m *Memory) PercentMemOccupied() float64 {
 		float64(m.currentMeasure.MemTotal)
 }
 
+// PercentMemUsed is the share of the memory used, excluding the buffers and
+// the caches the kernel can reclaim.
+func (m *Memory) PercentMemUsed() float64 {
+	return float64(m.currentMeasure.MemUsed) * 100.0 /
+		float64(m.currentMeasure.MemTotal)
+}
+
+// PercentMemBuffCache is the share of the memory used by the buffers and
+// the caches.
+func (m *Memory) PercentMemBuffCache() float64 {
+	return float64(m.currentMeasure.MemBuffCache) * 100.0 /
+		float64(m.currentMeasure.MemTotal)
+}
+
 func (m *Memory) PercentSwapFree() float64 {
 	return 100.0 - m.PercentSwapOccupied()
 }
@@ -97,6 +237,8 @@ func (m *Memory) String() string {
 	format += "MemTotal:\t %s\n"
 	format += "MemFree:\t %s\t%.3f %%\t(%s)\n"
 	format += "MemOccupied:\t %s\t%.3f %%\t(%s)\n"
+	format += "MemUsed:\t %s\t%.3f %%\t(%s)\n"
+	format += "Buff/Cache:\t %s\t%.3f %%\t(%s)\n"
 	format += "MemAvailable:\t %s\t\t\t(%s)\n"
 	format += "SwapTotal:\t %s\n"
 	format += "SwapFree:\t %s\t%.3f %%\t(%s)\n"
@@ -105,10 +247,12 @@ func (m *Memory) String() string {
 	format += "VmallocFree:\t %s\t%.3f %%\t(%s)\n"
 	format += "VmallocOccupied: %s\t%.3f %%\t\t(%s)"
 
-	return fmt.Sprintf(format,
+	str := fmt.Sprintf(format,
 		m.currentMeasure.MemTotal,
 		m.currentMeasure.MemFree, m.PercentMemFree(), m.currentMeasure.MemFree-m.lastMeasure.MemFree,
 		m.currentMeasure.MemOccupied, m.PercentMemOccupied(), m.currentMeasure.MemOccupied-m.lastMeasure.MemOccupied,
+		m.currentMeasure.MemUsed, m.PercentMemUsed(), m.currentMeasure.MemUsed-m.lastMeasure.MemUsed,
+		m.currentMeasure.MemBuffCache, m.PercentMemBuffCache(), m.currentMeasure.MemBuffCache-m.lastMeasure.MemBuffCache,
 		m.currentMeasure.MemAvailable, m.currentMeasure.MemAvailable-m.lastMeasure.MemAvailable,
 		m.currentMeasure.SwapTotal,
 		m.currentMeasure.SwapFree, m.PercentSwapFree(), m.currentMeasure.SwapFree-m.lastMeasure.SwapFree,
@@ -116,58 +260,108 @@ func (m *Memory) String() string {
 		m.currentMeasure.VmallocTotal,
 		m.currentMeasure.VmallocFree, m.PercentVmallocFree(), m.currentMeasure.VmallocFree-m.lastMeasure.VmallocFree,
 		m.currentMeasure.VmallocOccupied, m.PercentVmallocOccupied(), m.currentMeasure.VmallocOccupied-m.lastMeasure.VmallocOccupied)
+
+	str += "\n"
+
+	for _, name := range memoryFields {
+		value, ok := m.currentMeasure.Meminfo[name]
+		if !ok {
+			continue
+		}
+
+		if m.currentMeasure.counts[name] {
+			str += fmt.Sprintf("\n%-16s %d\t\t\t(%d)", name+":", value, m.Deltas[name])
+		} else {
+			str += fmt.Sprintf("\n%-16s %s\t\t\t(%s)", name+":", kbyte(value), kbyte(m.Deltas[name]))
+		}
+	}
+
+	return str
 }
 
 // update updates memoryMeasure parsing /proc/meminfo.
-func (m *memoryMeasure) update() error {
-	file, err := os.Open(meminfo)
+func (m *memoryMeasure) update(config *Config) error {
+	fileName := config.procPath(meminfo)
+
+	file, err := config.open(fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	var n int
+	// Nouvelle map : la mesure précédente garde la sienne pour les deltas
+	m.Meminfo = make(map[string]int64)
+	m.counts = make(map[string]bool)
+	m.names = m.names[:0]
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		if strings.Contains(line, "MemTotal") {
-			n, err = fmt.Sscanf(line, "MemTotal: %d kB", &m.MemTotal)
-			checkSscanf("MemTotal", err, n, 1)
-		} else if strings.Contains(line, "MemFree") {
-			n, err = fmt.Sscanf(line, "MemFree: %d kB", &m.MemFree)
-			checkSscanf("MemFree", err, n, 1)
-		} else if strings.Contains(line, "MemAvailable") {
-			n, err = fmt.Sscanf(line, "MemAvailable: %d kB", &m.MemAvailable)
-			checkSscanf("MemAvailable", err, n, 1)
-		} else if strings.Contains(line, "SwapTotal") {
-			n, err = fmt.Sscanf(line, "SwapTotal: %d kB", &m.SwapTotal)
-			checkSscanf("SwapTotal", err, n, 1)
-		} else if strings.Contains(line, "SwapFree") {
-			n, err = fmt.Sscanf(line, "SwapFree: %d kB", &m.SwapFree)
-			checkSscanf("SwapFree", err, n, 1)
-		} else if strings.Contains(line, "VmallocTotal") {
-			n, err = fmt.Sscanf(line, "VmallocTotal: %d kB", &m.VmallocTotal)
-			checkSscanf("VmallocTotal", err, n, 1)
-		} else if strings.Contains(line, "VmallocUsed") {
-			n, err = fmt.Sscanf(line, "VmallocUsed: %d kB", &m.VmallocOccupied)
-			checkSscanf("VmallocUsed", err, n, 1)
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+
+		name := line[:i]
+		fields := strings.Fields(line[i+1:])
+		if len(fields) == 0 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s field %s: %s", fileName, name, err)
+		}
+
+		if _, ok := m.Meminfo[name]; !ok {
+			m.names = append(m.names, name)
 		}
+
+		m.Meminfo[name] = value
+		m.counts[name] = len(fields) == 1
 	}
 
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	field := func(name string) kbyte { return kbyte(m.Meminfo[name]) }
+
+	m.MemTotal = field("MemTotal")
+	m.MemFree = field("MemFree")
+	m.MemAvailable = field("MemAvailable")
+	m.SwapTotal = field("SwapTotal")
+	m.SwapFree = field("SwapFree")
+	m.VmallocTotal = field("VmallocTotal")
+	m.VmallocOccupied = field("VmallocUsed")
+
 	m.MemOccupied = m.MemTotal - m.MemFree
 	m.SwapOccupied = m.SwapTotal - m.SwapFree
 	m.VmallocFree = m.VmallocTotal - m.VmallocOccupied
 
+	// Comme free(1) : le cache et les slabs récupérables ne sont pas
+	// de la mémoire utilisée
+	m.MemBuffCache = field("Buffers") + field("Cached") + field("SReclaimable")
+	m.MemUsed = m.MemOccupied - m.MemBuffCache
+	if m.MemUsed < 0 {
+		m.MemUsed = m.MemOccupied
+	}
+
 	return nil
 }
 
-func (m *memoryMeasure) MarshalCSV() ([]byte, error) {
-	str := fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d\n",
-		m.MemTotal, m.MemFree, m.MemOccupied, m.MemAvailable,
-		m.SwapTotal, m.SwapFree, m.SwapOccupied)
+// csvColumn returns the CSV column of a field of /proc/meminfo:
+// "Active(anon)" becomes "active_anon_kb".
+func (m *memoryMeasure) csvColumn(name string) string {
+	column := strings.ToLower(strings.NewReplacer("(", "_", ")", "").Replace(name))
+
+	if !m.counts[name] {
+		column += "_kb"
+	}
 
-	return []byte(str), nil
+	return column
 }
 
 type kbyte int
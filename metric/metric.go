@@ -1,16 +1,22 @@
 package metric
 
 import (
-	_ "encoding/json"
+	"bytes"
+	"encoding/json"
 	"fmt"
-	_ "io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	// TODO : l'utiliser
-	CSVSeparator = ","
-	fileOpenMode = os.O_CREATE | os.O_WRONLY | os.O_APPEND | os.O_TRUNC
+	CSVSeparator    = ","
+	CSVTimeFormat   = "2006-01-02T15:04:05.000Z07:00"
+	fileOpenMode    = os.O_CREATE | os.O_WRONLY | os.O_APPEND | os.O_TRUNC
+	appendOpenMode  = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	csvTimestamp    = "timestamp"
+	csvUnixMilliSec = "unix_ms"
 )
 
 type Metric interface {
@@ -22,14 +28,19 @@ type Metric interface {
 type marshaler interface {
 	MarshalJSON() ([]byte, error)
 	MarshalCSV() ([]byte, error)
+	MarshalPrometheus() ([]byte, error)
+
+	// CSVHeader returns the names of the columns of MarshalCSV.
+	CSVHeader() []string
 }
 
 type Mode string
 
 var (
-	ModeCSV  = Mode("csv")
-	ModeJSON = Mode("json")
-	ModeWEB  = Mode("web")
+	ModeCSV    = Mode("csv")
+	ModeJSON   = Mode("json")
+	ModeNDJSON = Mode("ndjson")
+	ModeWEB    = Mode("web")
 )
 
 func (m Mode) GetExtension() string {
@@ -40,22 +51,56 @@ type saver struct {
 	file      *os.File
 	mode      Mode
 	marshaler marshaler
+	header    string // Dernier en-tête CSV écrit
+	name      string
+	host      string
+	now       func() time.Time
+}
+
+// ndjsonRecord is a line of the NDJSON mode.
+type ndjsonRecord struct {
+	Timestamp string          `json:"timestamp"`
+	UnixMs    int64           `json:"unix_ms"`
+	Host      string          `json:"host"`
+	Metric    string          `json:"metric"`
+	Values    json.RawMessage `json:"values"`
 }
 
 func newSaver(config *Config, marshaler marshaler, fileName string) (*saver, error) {
+	// En mode web, les mesures sont servies en HTTP : aucun fichier
+	if config.Mode == ModeWEB {
+		return &saver{marshaler: marshaler, mode: config.Mode, now: config.now}, nil
+	}
+
+	name := fileName
 	fileName =
 		config.OutputDir + fileName + "." + config.Mode.GetExtension()
 
-	_ = os.Remove(fileName)
-	file, err := os.OpenFile(fileName, fileOpenMode, 0666)
+	// Chaque ligne NDJSON se suffit à elle-même : les mesures des
+	// précédentes exécutions sont conservées
+	openMode := appendOpenMode
+	if config.Mode != ModeNDJSON {
+		_ = os.Remove(fileName)
+		openMode = fileOpenMode
+	}
+
+	file, err := os.OpenFile(fileName, openMode, 0666)
 	if err != nil {
 		return nil, err
 	}
 
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
 	return &saver{
 		file:      file,
 		marshaler: marshaler,
 		mode:      config.Mode,
+		name:      name,
+		host:      host,
+		now:       config.now,
 	}, nil
 }
 
@@ -64,7 +109,7 @@ func (s *saver) Save() (err error) {
 
 	switch s.mode {
 	case ModeCSV:
-		b, err = s.marshaler.MarshalCSV()
+		b, err = s.marshalCSV(s.now())
 
 	case ModeJSON:
 		first := true
@@ -102,6 +147,13 @@ func (s *saver) Save() (err error) {
 
 		b = append(b, js...)
 		b = append(b, byte(']'))
+
+	case ModeNDJSON:
+		b, err = s.marshalNDJSON(s.now())
+
+	case ModeWEB:
+		return nil
+
 	default:
 		// Ne devrait pas arriver puisque vérifier en amont
 		err = fmt.Errorf("invalid mode '%s'", string(s.mode))
@@ -115,7 +167,84 @@ func (s *saver) Save() (err error) {
 	return err
 }
 
+// marshalCSV prefixes every row of the marshaler with the time of the
+// measure. The header is written in front of the first row and again each
+// time the columns change (new network interface, new disk, ...).
+func (s *saver) marshalCSV(now time.Time) ([]byte, error) {
+	rows, err := s.marshaler.MarshalCSV()
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+
+	header := strings.Join(append([]string{csvTimestamp, csvUnixMilliSec},
+		s.marshaler.CSVHeader()...), CSVSeparator) + "\n"
+
+	if header != s.header {
+		b = append(b, header...)
+		s.header = header
+	}
+
+	prefix := now.Format(CSVTimeFormat) + CSVSeparator +
+		strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10) + CSVSeparator
+
+	for _, row := range bytes.Split(rows, []byte("\n")) {
+		if len(row) == 0 {
+			continue
+		}
+
+		b = append(b, prefix...)
+		b = append(b, row...)
+		b = append(b, '\n')
+	}
+
+	return b, nil
+}
+
+// marshalNDJSON returns a self-describing JSON object followed by a new line,
+// so that the file can be read while it is written.
+func (s *saver) marshalNDJSON(now time.Time) ([]byte, error) {
+	values, err := s.marshaler.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(ndjsonRecord{
+		Timestamp: now.Format(CSVTimeFormat),
+		UnixMs:    now.UnixNano() / int64(time.Millisecond),
+		Host:      s.host,
+		Metric:    s.name,
+		Values:    values,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// csvEscape quotes a CSV field when it contains a separator, a quote or a
+// new line.
+func csvEscape(field string) string {
+	if !strings.ContainsAny(field, CSVSeparator+"\"\r\n") {
+		return field
+	}
+
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
+
 func (s *saver) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Sync()
+	if err != nil {
+		s.file.Close()
+		return err
+	}
+
 	return s.file.Close()
 }
 
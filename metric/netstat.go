@@ -0,0 +1,294 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snmp              = "net/snmp"
+	snmp6             = "net/snmp6"
+	netstat           = "net/netstat"
+	netstatOutputFile = "netstat"
+)
+
+// Netstat is the activity of the network protocols: TCP connections,
+// retransmits and resets, listen queue overflows, UDP errors and IP
+// fragmentation.
+type Netstat struct {
+	saver
+	config                 *Config
+	counters, lastCounters map[string]int64 // "Tcp.ActiveOpens" -> valeur
+	time, lastTime         time.Time
+	Rates                  map[string]float64 // Par seconde
+}
+
+// netstatCounter is a counter of /proc/net/snmp, snmp6 or netstat whose rate
+// is reported.
+type netstatCounter struct {
+	name, description string
+}
+
+var netstatCounters = []netstatCounter{
+	{"Tcp.ActiveOpens", "connections opened"},
+	{"Tcp.PassiveOpens", "connections accepted"},
+	{"Tcp.AttemptFails", "failed connection attempts"},
+	{"Tcp.EstabResets", "established connections reset"},
+	{"Tcp.OutRsts", "resets sent"},
+	{"Tcp.InSegs", "segments received"},
+	{"Tcp.OutSegs", "segments sent"},
+	{"Tcp.RetransSegs", "segments retransmitted"},
+	{"Tcp.InErrs", "bad segments received"},
+	{"TcpExt.TCPTimeouts", "retransmit timeouts"},
+	{"TcpExt.TCPSynRetrans", "SYN retransmitted"},
+	{"TcpExt.ListenOverflows", "listen queue overflows"},
+	{"TcpExt.ListenDrops", "SYN dropped by a listener"},
+	{"TcpExt.SyncookiesSent", "SYN cookies sent"},
+	{"Udp.InDatagrams", "datagrams received"},
+	{"Udp.OutDatagrams", "datagrams sent"},
+	{"Udp.NoPorts", "datagrams to no port"},
+	{"Udp.InErrors", "receive errors"},
+	{"Udp.RcvbufErrors", "receive buffer errors"},
+	{"Udp.SndbufErrors", "send buffer errors"},
+	{"Udp6.InDatagrams", "datagrams received"},
+	{"Udp6.OutDatagrams", "datagrams sent"},
+	{"Udp6.InErrors", "receive errors"},
+	{"Udp6.RcvbufErrors", "receive buffer errors"},
+	{"Udp6.SndbufErrors", "send buffer errors"},
+	{"Ip.ReasmReqds", "fragments to reassemble"},
+	{"Ip.ReasmOKs", "packets reassembled"},
+	{"Ip.ReasmFails", "reassembly failures"},
+	{"Ip.FragOKs", "packets fragmented"},
+	{"Ip.FragFails", "fragmentation failures"},
+	{"Ip.FragCreates", "fragments created"},
+	{"Ip6.ReasmReqds", "fragments to reassemble"},
+	{"Ip6.ReasmOKs", "packets reassembled"},
+	{"Ip6.ReasmFails", "reassembly failures"},
+	{"Ip6.FragOKs", "packets fragmented"},
+	{"Ip6.FragFails", "fragmentation failures"},
+	{"Ip6.FragCreates", "fragments created"},
+}
+
+// Compteur de /proc/net/snmp qui est une jauge
+const tcpCurrEstab = "Tcp.CurrEstab"
+
+func init() {
+	mustRegister("netstat", "TCP, UDP and IP protocols statistics (/proc/net/snmp, netstat)", false,
+		func(config *Config) (Metric, error) { return NewNetstat(config) })
+}
+
+func NewNetstat(config *Config) (*Netstat, error) {
+	netstat := &Netstat{}
+
+	saver, err := newSaver(config, netstat, netstatOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	netstat.saver = *saver
+	netstat.config = config
+	netstat.counters = make(map[string]int64)
+	netstat.Rates = make(map[string]float64)
+
+	return netstat, nil
+}
+
+func (n *Netstat) Update() error {
+	n.lastCounters, n.lastTime = n.counters, n.time
+	n.counters = make(map[string]int64)
+	n.time = n.config.now()
+
+	err := n.readTable(snmp)
+	if err != nil {
+		return err
+	}
+
+	// netstat et snmp6 manquent sans TCP étendu ou sans IPv6
+	err = n.readTable(netstat)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = n.readSnmp6()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	n.computeRates()
+
+	return nil
+}
+
+// readTable parses a file made of pairs of lines, the names of the counters
+// of a protocol then their values: "Tcp: ActiveOpens ..." "Tcp: 12 ...".
+func (n *Netstat) readTable(name string) error {
+	fileName := n.config.procPath(name)
+
+	b, err := n.config.readFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+
+	for i := 0; i+1 < len(lines); i += 2 {
+		names, values := strings.Fields(lines[i]), strings.Fields(lines[i+1])
+
+		if len(names) != len(values) || len(names) == 0 || names[0] != values[0] {
+			return fmt.Errorf("invalid %s lines %d and %d", fileName, i+1, i+2)
+		}
+
+		protocol := strings.TrimSuffix(names[0], ":")
+
+		for j := 1; j < len(names); j++ {
+			value, err := strconv.ParseInt(values[j], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %s.%s: %s", fileName, protocol, names[j], err)
+			}
+
+			n.counters[protocol+"."+names[j]] = value
+		}
+	}
+
+	return nil
+}
+
+// readSnmp6 parses /proc/net/snmp6, one counter per line: "Udp6InErrors 0"
+// is stored as "Udp6.InErrors".
+func (n *Netstat) readSnmp6() error {
+	fileName := n.config.procPath(snmp6)
+
+	b, err := n.config.readFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		i := strings.IndexByte(fields[0], '6')
+		if i < 0 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %s", fileName, fields[0], err)
+		}
+
+		n.counters[fields[0][:i+1]+"."+fields[0][i+1:]] = value
+	}
+
+	return nil
+}
+
+// computeRates computes the rate of the reported counters. A counter missing
+// from one of the measures or reset has no rate.
+func (n *Netstat) computeRates() {
+	n.Rates = make(map[string]float64)
+
+	if n.lastTime.IsZero() {
+		return
+	}
+
+	seconds := n.time.Sub(n.lastTime).Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	for _, c := range netstatCounters {
+		value, ok := n.counters[c.name]
+		last, lastOk := n.lastCounters[c.name]
+		if !ok || !lastOk {
+			continue
+		}
+
+		if delta, ok := counterDelta(uint64(last), uint64(value), counter64); ok {
+			n.Rates[c.name] = float64(delta) / seconds
+		}
+	}
+}
+
+// reported returns the reported counters the kernel exposes.
+func (n *Netstat) reported() []netstatCounter {
+	var counters []netstatCounter
+
+	for _, c := range netstatCounters {
+		if _, ok := n.counters[c.name]; ok {
+			counters = append(counters, c)
+		}
+	}
+
+	return counters
+}
+
+func (n *Netstat) CSVHeader() []string {
+	header := []string{"tcp_curr_estab"}
+
+	for _, c := range n.reported() {
+		header = append(header, strings.ToLower(strings.Replace(c.name, ".", "_", -1))+"_per_s")
+	}
+
+	return header
+}
+
+func (n *Netstat) MarshalCSV() ([]byte, error) {
+	values := []string{strconv.FormatInt(n.counters[tcpCurrEstab], 10)}
+
+	for _, c := range n.reported() {
+		values = append(values, fmt.Sprintf("%.2f", n.Rates[c.name]))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (n *Netstat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"tcp-curr-estab": n.counters[tcpCurrEstab],
+		"rates":          n.Rates,
+		"counters":       n.counters,
+	})
+}
+
+func (n *Netstat) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	p.family("netstat_tcp_established", prometheusGauge, "TCP connections currently established.")
+	p.sample(float64(n.counters[tcpCurrEstab]))
+
+	p.family("netstat_total", prometheusCounter,
+		"Counters of /proc/net/snmp, snmp6 and netstat by protocol.")
+	for _, c := range n.reported() {
+		i := strings.IndexByte(c.name, '.')
+		p.sample(float64(n.counters[c.name]), "protocol", c.name[:i], "counter", c.name[i+1:])
+	}
+
+	return p.Bytes(), nil
+}
+
+func (n *Netstat) String() string {
+	str := "\t========== NETSTAT ==========\n\n"
+	str += fmt.Sprintf("TCP established: \t%d\n", n.counters[tcpCurrEstab])
+
+	// Regroupés par protocole, dans l'ordre de netstatCounters
+	protocol := ""
+	for _, c := range n.reported() {
+		i := strings.IndexByte(c.name, '.')
+
+		if c.name[:i] != protocol {
+			protocol = c.name[:i]
+			str += "\n" + protocol + ":\n"
+		}
+
+		str += fmt.Sprintf("  %-16s\t%10.2f /s\t%s\n", c.name[i+1:], n.Rates[c.name], c.description)
+	}
+
+	return strings.TrimRight(str, "\n")
+}
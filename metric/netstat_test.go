@@ -0,0 +1,70 @@
+package metric
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetstatReadTable(t *testing.T) {
+	n := &Netstat{config: &Config{ProcRoot: "testdata/proc"}, counters: make(map[string]int64)}
+
+	err := n.readTable(snmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{
+		"Ip.Forwarding": 1, "Ip.DefaultTTL": 64, "Ip.InReceives": 123456,
+		"Ip.ReasmReqds": 4, "Ip.ReasmOKs": 2, "Ip.ReasmFails": 0,
+		"Ip.FragOKs": 1, "Ip.FragFails": 0, "Ip.FragCreates": 2,
+		"Tcp.RtoAlgorithm": 1, "Tcp.RtoMin": 200, "Tcp.RtoMax": 120000, "Tcp.MaxConn": -1,
+		"Tcp.ActiveOpens": 150, "Tcp.PassiveOpens": 30, "Tcp.AttemptFails": 3,
+		"Tcp.EstabResets": 7, "Tcp.CurrEstab": 12, "Tcp.InSegs": 98765, "Tcp.OutSegs": 87654,
+		"Tcp.RetransSegs": 21, "Tcp.InErrs": 0, "Tcp.OutRsts": 9,
+		"Udp.InDatagrams": 4321, "Udp.NoPorts": 5, "Udp.InErrors": 1,
+		"Udp.OutDatagrams": 4000, "Udp.RcvbufErrors": 0, "Udp.SndbufErrors": 0,
+	}
+
+	if !reflect.DeepEqual(n.counters, want) {
+		t.Errorf("readTable(%s) = %v, want %v", snmp, n.counters, want)
+	}
+}
+
+func TestNetstatReadTableInvalid(t *testing.T) {
+	tests := []struct {
+		root, name string
+	}{
+		{"testdata/proc-invalid", snmp}, // Moins de valeurs que de noms
+		{"testdata/proc-values", snmp},  // Valeur non numérique
+		{"testdata/proc", netstat},      // Fichier absent
+	}
+
+	for _, test := range tests {
+		n := &Netstat{config: &Config{ProcRoot: test.root}, counters: make(map[string]int64)}
+
+		if err := n.readTable(test.name); err == nil {
+			t.Errorf("readTable(%s/%s) = %v, want an error", test.root, test.name, n.counters)
+		}
+	}
+}
+
+func TestNetstatUpdateOptionalFiles(t *testing.T) {
+	tests := []struct {
+		root string
+		ok   bool
+	}{
+		{"testdata/proc", true},          // Ni netstat ni snmp6
+		{"testdata/proc-netstat", false}, // netstat invalide
+	}
+
+	for _, test := range tests {
+		n, err := NewNetstat(&Config{ProcRoot: test.root, Mode: ModeWEB})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = n.Update(); (err == nil) != test.ok {
+			t.Errorf("Update() of %s = %v, want ok %v", test.root, err, test.ok)
+		}
+	}
+}
@@ -4,30 +4,89 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	dev           = "/proc/net/dev"
+	dev           = "net/dev"
 	netOutputFile = "net"
 	nbNetColumns  = 16
 )
 
+// Index des colonnes de /proc/net/dev
+const (
+	netReceiveBytes = iota
+	netReceivePackets
+	netReceiveErrors
+	netReceiveDrops
+	netReceiveFifo
+	netReceiveFrame
+	netReceiveCompressed
+	netReceiveMulticast
+	netTransmitBytes
+	netTransmitPackets
+	netTransmitErrors
+	netTransmitDrops
+	netTransmitFifo
+	netTransmitCollisions
+	netTransmitCarrier
+	netTransmitCompressed
+)
+
+// netColumns are the names of the columns of /proc/net/dev.
+var netColumns = [nbNetColumns]string{
+	"rx_bytes", "rx_packets", "rx_errors", "rx_drops",
+	"rx_fifo", "rx_frame", "rx_compressed", "rx_multicast",
+	"tx_bytes", "tx_packets", "tx_errors", "tx_drops",
+	"tx_fifo", "tx_collisions", "tx_carrier", "tx_compressed",
+}
+
+// netWrapMargin is how close to 2^32 a counter of /proc/net/dev must be for a
+// decrease to be taken for the wrap of a 32 bits hardware counter.
+const netWrapMargin = 1 << 28
+
+// netDevBits returns the width of a counter of /proc/net/dev whose last value
+// is last. The counters are 64 bits, but some drivers only expose 32 bits
+// hardware counters: a counter close enough to 2^32 may have wrapped.
+func netDevBits(last uint64) uint {
+	if last <= math.MaxUint32 && math.MaxUint32-last < netWrapMargin {
+		return counter32
+	}
+
+	return counter64
+}
+
 type Network struct {
 	saver
-	config       *Config
-	measures     map[string]*networkInterface
-	lastMeasures map[string]*networkInterface
+	config           *Config
+	measures         map[string]*networkInterface
+	lastMeasures     map[string]*networkInterface
+	time, lastTime   time.Time
+	include, exclude []string
+	unit             rateUnit
 }
 
 type networkInterface struct {
-	Name     string              `json:"-"`
-	Download float64             `json:"download"`
-	Upload   float64             `json:"updaload"`
-	Measure  [nbNetColumns]int64 `json:"-"`
+	Name     string                `json:"-"`
+	Download float64               `json:"download"` // En méga-unités de -bits et -iec
+	Upload   float64               `json:"updaload"`
+	Rates    [nbNetColumns]float64 `json:"-"` // Par seconde
+	Measure  [nbNetColumns]uint64  `json:"-"`
+
+	// Lus dans /sys/class/net/<interface>
+	Speed     int    `json:"speed"` // Mb/s, -1 si inconnue
+	MTU       int    `json:"mtu"`
+	OperState string `json:"operstate"`
+	MAC       string `json:"mac"`
+}
+
+func init() {
+	mustRegister("net", "Network throughput, errors and link state per interface (/proc/net/dev)", true,
+		func(config *Config) (Metric, error) { return NewNetwork(config) })
 }
 
 func NewNetwork(config *Config) (*Network, error) {
@@ -42,41 +101,63 @@ func NewNetwork(config *Config) (*Network, error) {
 	net.config = config
 	net.measures = make(map[string]*networkInterface)
 	net.lastMeasures = make(map[string]*networkInterface)
+	net.include = splitList(config.NetInclude)
+	net.exclude = splitList(config.NetExclude)
+	net.unit = config.rateUnit()
 
 	return net, nil
 }
 
 func (n *Network) Update() error {
-	n.lastMeasures = n.measures
+	n.lastMeasures, n.lastTime = n.measures, n.time
 	n.measures = make(map[string]*networkInterface)
 
-	file, err := os.Open(dev)
+	fileName := n.config.procPath(dev)
+
+	file, err := n.config.open(fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	var data [nbNetColumns]int64
-	var interfaceName string
+	n.time = n.config.now()
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		if strings.Contains(line, ":") {
-			fields := strings.Fields(line)
-			interfaceName = fields[0][:len(fields[0])-1]
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
 
-			for i := 0; i < len(data); i++ {
-				data[i], err = strconv.ParseInt(fields[i+1], 10, 0)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
+		name := strings.TrimSpace(line[:i])
+		if !isSelected(name, n.include, n.exclude) {
+			continue
+		}
 
-			n.measures[interfaceName] = &networkInterface{
-				interfaceName, 0.0, 0.0, data,
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < nbNetColumns {
+			return fmt.Errorf("invalid %s line for %s: %d columns", fileName, name, len(fields))
+		}
+
+		v := &networkInterface{Name: name}
+
+		for i := 0; i < nbNetColumns; i++ {
+			v.Measure[i], err = strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s column %d for %s: %s", fileName, i+1, name, err)
 			}
 		}
+
+		v.readLink(n.config)
+
+		n.measures[name] = v
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return err
 	}
 
 	n.computeNetworkSpeed()
@@ -84,53 +165,201 @@ func (n *Network) Update() error {
 	return nil
 }
 
+// readLink reads the link state of the interface in sysfs. The attributes
+// the interface does not have (speed of lo, of a link down, ...) are left
+// unknown.
+func (v *networkInterface) readLink(config *Config) {
+	attribute := func(name string) string {
+		b, err := config.readFile(config.sysPath("class", "net", v.Name, name))
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimSpace(string(b))
+	}
+
+	v.Speed = -1
+	if speed, err := strconv.Atoi(attribute("speed")); err == nil {
+		v.Speed = speed
+	}
+
+	v.MTU, _ = strconv.Atoi(attribute("mtu"))
+	v.OperState = attribute("operstate")
+	v.MAC = attribute("address")
+}
+
+// computeNetworkSpeed computes the rates of every column per second from the
+// time elapsed between the two measures, and the download and upload speeds
+// in the unit of the configuration. A reset counter has no rate.
 func (n *Network) computeNetworkSpeed() {
-	for k, _ := range n.measures {
-		if n.lastMeasures[k] != nil {
-			n.measures[k].Download = float64(n.measures[k].Measure[0]-n.lastMeasures[k].Measure[0]) / float64(1000000)
-			n.measures[k].Upload = float64(n.measures[k].Measure[9]-n.lastMeasures[k].Measure[9]) / float64(1000000)
+	if n.lastTime.IsZero() {
+		return
+	}
+
+	seconds := n.time.Sub(n.lastTime).Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	for name, v := range n.measures {
+		last := n.lastMeasures[name]
+		if last == nil {
+			continue
 		}
+
+		for i := range v.Measure {
+			if delta, ok := counterDelta(last.Measure[i], v.Measure[i], netDevBits(last.Measure[i])); ok {
+				v.Rates[i] = float64(delta) / seconds
+			}
+		}
+
+		v.Download = n.unit.mega(v.Received())
+		v.Upload = n.unit.mega(v.Transmitted())
 	}
 }
 
+// Received returns the bytes received per second.
+func (v *networkInterface) Received() float64 {
+	return v.Rates[netReceiveBytes]
+}
+
+// Transmitted returns the bytes transmitted per second.
+func (v *networkInterface) Transmitted() float64 {
+	return v.Rates[netTransmitBytes]
+}
+
+// Interfaces returns the network interfaces sorted by name.
+func (n *Network) Interfaces() []*networkInterface {
+	names := make([]string, 0, len(n.measures))
+	for name := range n.measures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	interfaces := make([]*networkInterface, len(names))
+	for i, name := range names {
+		interfaces[i] = n.measures[name]
+	}
+
+	return interfaces
+}
+
+func (n *Network) CSVHeader() []string {
+	var header []string
+
+	for _, v := range n.Interfaces() {
+		header = append(header, v.Name+"_rx_"+n.unit.megaName(), v.Name+"_tx_"+n.unit.megaName())
+
+		for i := range v.Rates {
+			if i != netReceiveBytes && i != netTransmitBytes {
+				header = append(header, v.Name+"_"+netColumns[i]+"_per_s")
+			}
+		}
+
+		header = append(header, v.Name+"_speed_Mbps", v.Name+"_mtu", v.Name+"_operstate")
+	}
+
+	return header
+}
+
 func (n *Network) MarshalCSV() ([]byte, error) {
-	str := ""
-	i, length := 0, len(n.measures)
+	var values []string
 
-	for _, v := range n.measures {
-		str += fmt.Sprintf("%f,%f", v.Download, v.Upload)
+	for _, v := range n.Interfaces() {
+		values = append(values, fmt.Sprintf("%f", v.Download), fmt.Sprintf("%f", v.Upload))
 
-		if i != length-1 {
-			str += ","
+		for i, rate := range v.Rates {
+			if i != netReceiveBytes && i != netTransmitBytes {
+				values = append(values, fmt.Sprintf("%.2f", rate))
+			}
 		}
 
-		i++
+		values = append(values, strconv.Itoa(v.Speed), strconv.Itoa(v.MTU), csvEscape(v.OperState))
 	}
-	str += "\n"
 
-	return []byte(str), nil
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
 }
 
 func (n *Network) MarshalJSON() ([]byte, error) {
-	return json.Marshal(n.measures)
+	m := make(map[string]interface{}, len(n.measures))
+
+	for name, v := range n.measures {
+		rates := make(map[string]float64, nbNetColumns)
+		for i, rate := range v.Rates {
+			rates[strings.Replace(netColumns[i], "_", "-", -1)] = rate
+		}
+
+		m[name] = struct {
+			*networkInterface
+			Rates map[string]float64 `json:"rates"`
+		}{v, rates}
+	}
+
+	return json.Marshal(m)
 }
 
-func (n *Network) String() string {
-	str := "\t========== NETWORK ==========\n\n"
-	for _, v := range n.measures {
-		str += fmt.Sprintf("%s:\tDownload: %f MB/s,\tUpload: %f MB/s\n",
-			v.Name, v.Download, v.Upload)
+func (n *Network) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	interfaces := n.Interfaces()
+
+	for column, name := range netColumns {
+		direction, done, counter := "receive", "received", strings.TrimPrefix(name, "rx_")
+		if column >= netTransmitBytes {
+			direction, done, counter = "transmit", "transmitted", strings.TrimPrefix(name, "tx_")
+		}
+
+		p.family(fmt.Sprintf("network_%s_%s_total", direction, counter), prometheusCounter,
+			fmt.Sprintf("Number of %s %s by each interface.", counter, done))
+		for _, i := range interfaces {
+			p.sample(float64(i.Measure[column]), "interface", i.Name)
+		}
 	}
-	return str
+
+	p.family("network_speed_bytes", prometheusGauge, "Link speed of each interface.")
+	for _, i := range interfaces {
+		if i.Speed >= 0 {
+			p.sample(float64(i.Speed)*1000000/8, "interface", i.Name)
+		}
+	}
+
+	p.family("network_mtu_bytes", prometheusGauge, "MTU of each interface.")
+	for _, i := range interfaces {
+		p.sample(float64(i.MTU), "interface", i.Name)
+	}
+
+	p.family("network_up", prometheusGauge, "Whether the operational state of each interface is up.")
+	for _, i := range interfaces {
+		up := 0.0
+		if i.OperState == "up" {
+			up = 1
+		}
+
+		p.sample(up, "interface", i.Name, "operstate", i.OperState, "address", i.MAC)
+	}
+
+	return p.Bytes(), nil
 }
 
-func isInterface(str string) bool {
-	valid := false
+func (n *Network) String() string {
+	str := "\t========== NETWORK ==========\n\n"
 
-	switch str {
-	case "wlan0", "l0":
-		valid = true
+	for _, v := range n.Interfaces() {
+		speed := "?"
+		if v.Speed >= 0 {
+			speed = fmt.Sprintf("%d Mb/s", v.Speed)
+		}
+
+		str += fmt.Sprintf("%s:\t%s, %s, mtu %d, %s\n", v.Name, v.OperState, speed, v.MTU, v.MAC)
+		str += fmt.Sprintf("\tDownload: %s,\tUpload: %s\n",
+			n.unit.format(v.Received()), n.unit.format(v.Transmitted()))
+		str += fmt.Sprintf("\tRX: %.1f pkt/s, %.1f err/s, %.1f drop/s, %.1f fifo/s, %.1f frame/s, %.1f mcast/s\n",
+			v.Rates[netReceivePackets], v.Rates[netReceiveErrors], v.Rates[netReceiveDrops],
+			v.Rates[netReceiveFifo], v.Rates[netReceiveFrame], v.Rates[netReceiveMulticast])
+		str += fmt.Sprintf("\tTX: %.1f pkt/s, %.1f err/s, %.1f drop/s, %.1f fifo/s, %.1f colls/s, %.1f carrier/s\n",
+			v.Rates[netTransmitPackets], v.Rates[netTransmitErrors], v.Rates[netTransmitDrops],
+			v.Rates[netTransmitFifo], v.Rates[netTransmitCollisions], v.Rates[netTransmitCarrier])
 	}
 
-	return valid
+	return str
 }
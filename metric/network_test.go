@@ -0,0 +1,41 @@
+package metric
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNetworkCounterResetAndWrap(t *testing.T) {
+	tests := []struct {
+		name          string
+		last, current uint64
+		rate          float64
+	}{
+		{"increase", 1000, 3000, 2000},
+		{"32 bits wrap", math.MaxUint32 - 999, 1000, 2000},
+		// Interface recréée : aucun débit plutôt qu'un pic de 1,3 Go
+		{"reset above 2^31", 3000000000, 1000, 0},
+		{"reset", 5000, 1000, 0},
+		{"64 bits reset", 1 << 40, 1000, 0},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		n := &Network{
+			lastTime:     now.Add(-time.Second),
+			time:         now,
+			lastMeasures: map[string]*networkInterface{"eth0": {Name: "eth0"}},
+			measures:     map[string]*networkInterface{"eth0": {Name: "eth0"}},
+		}
+
+		n.lastMeasures["eth0"].Measure[netReceiveBytes] = test.last
+		n.measures["eth0"].Measure[netReceiveBytes] = test.current
+
+		n.computeNetworkSpeed()
+
+		if rate := n.measures["eth0"].Rates[netReceiveBytes]; rate != test.rate {
+			t.Errorf("%s: rate from %d to %d = %.0f/s, want %.0f/s", test.name, test.last, test.current, rate, test.rate)
+		}
+	}
+}
@@ -2,34 +2,78 @@ package metric
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/user"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	procdir            = "/proc"
 	procOutputFileName = "proc"
+
+	// userHZ is the unit of the times of /proc/<pid>/stat. It is part of
+	// the kernel ABI and is 100 on every architecture Linux supports.
+	userHZ = 100
 )
 
-var statfile = "stat"
+var (
+	statfile  = "stat"
+	statmfile = "statm"
+	cmdline   = "cmdline"
+
+	validProcessID = regexp.MustCompile(`^[0-9]+$`)
+	pageSize       = kbyte(os.Getpagesize() / 1024)
+)
+
+// Process sort orders.
+const (
+	SortByPid    = "pid"
+	SortByCPU    = "cpu"
+	SortByMemory = "mem"
+)
 
 type Processes struct {
 	saver
 	config    *Config
 	Processes processes
+	sortBy    string
+	last      map[int]Process
+	users     map[uint32]string
+	time      time.Time
+	lastTime  time.Time
 }
 
 type processes []Process
 
 type Process struct {
-	Pid, Ppid, Pgrp, Nice, NumThreads int
-	Name, State                       string
-	Stime, Utime                      uint64
+	Pid        int       `json:"pid"`
+	Ppid       int       `json:"ppid"`
+	Pgrp       int       `json:"pgrp"`
+	Nice       int       `json:"nice"`
+	NumThreads int       `json:"threads"`
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	User       string    `json:"user"`
+	Command    string    `json:"command"`
+	Stime      uint64    `json:"stime"`
+	Utime      uint64    `json:"utime"`
+	StartTime  uint64    `json:"start-time"`
+	CPU        float64   `json:"cpu"`
+	RSS        kbyte     `json:"rss"`
+	VirtualMem kbyte     `json:"virtual-memory"`
+	Container  container `json:"container"`
+}
+
+func init() {
+	mustRegister("proc", "Processes CPU and memory usage (/proc/<pid>)", false,
+		func(config *Config) (Metric, error) { return NewProcesses(config) })
 }
 
 func NewProcesses(config *Config) (*Processes, error) {
@@ -42,6 +86,13 @@ func NewProcesses(config *Config) (*Processes, error) {
 
 	proc.saver = *saver
 	proc.config = config
+	proc.last = make(map[int]Process)
+	proc.users = make(map[uint32]string)
+
+	err = proc.SortBy(config.ProcessSort)
+	if err != nil {
+		return nil, err
+	}
 
 	return proc, nil
 }
@@ -52,86 +103,342 @@ func (p *Process) IsRunning() bool {
 
 func (p *Processes) Update() error {
 	p.Processes = nil
+	p.lastTime, p.time = p.time, p.config.now()
 
-	files, err := ioutil.ReadDir(procdir)
+	files, err := p.config.pidDirs()
 	if err != nil {
 		return err
 	}
 
-	var validProcessID = regexp.MustCompile(`^[0-9]*$`)
+	last := p.last
+	p.last = make(map[int]Process, len(last))
+
+	// Mount namespace de l'hôte, illisible sans privilèges
+	hostNS, _ := p.config.readlink(p.config.procPath("1", "ns", "mnt"))
 
 	for _, file := range files {
-		if file.IsDir() && validProcessID.MatchString(file.Name()) {
-			p.readStatPid(file.Name())
+		process, err := p.readPid(file)
+		if err != nil {
+			// Le processus a pu se terminer entre temps
+			continue
 		}
+
+		p.computeCPU(process, last)
+		p.identify(process, last, hostNS)
+		p.last[process.Pid] = *process
+		p.Processes = append(p.Processes, *process)
 	}
 
-	sort.Sort(p.Processes)
+	p.sort()
 
 	return nil
 }
 
-func (p *Processes) readStatPid(pid string) {
-	file, err := os.Open(procdir + "/" + pid + "/" + statfile)
+// pidDirs returns the directories of the processes in the proc filesystem.
+func (c *Config) pidDirs() ([]os.FileInfo, error) {
+	files, err := c.readDir(c.procPath())
 	if err != nil {
+		return nil, err
+	}
+
+	var pids []os.FileInfo
+
+	for _, file := range files {
+		if file.IsDir() && validProcessID.MatchString(file.Name()) {
+			pids = append(pids, file)
+		}
+	}
+
+	return pids, nil
+}
+
+// computeCPU computes the CPU usage of a process since the last update. The
+// start time tells apart a process from a new one reusing its pid.
+func (p *Processes) computeCPU(process *Process, last map[int]Process) {
+	previous, ok := last[process.Pid]
+	if !ok || previous.StartTime != process.StartTime || p.lastTime.IsZero() {
 		return
 	}
-	defer file.Close()
 
-	var process = Process{}
+	seconds := p.time.Sub(p.lastTime).Seconds()
+	if seconds <= 0 {
+		return
+	}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Scan()
-	line := scanner.Text()
-	fields := strings.Fields(line)
+	ticks, ok := counterDelta(previous.Utime+previous.Stime, process.Utime+process.Stime, counter64)
+	if !ok {
+		return
+	}
 
-	process.Pid, err = strconv.Atoi(fields[0])
-	if err != nil {
+	process.CPU = float64(ticks) * 100.0 / userHZ / seconds
+}
+
+// identify finds the container and the systemd unit of a process. They are
+// kept from the last update for the same process: moving a process to
+// another cgroup is rare enough.
+func (p *Processes) identify(process *Process, last map[int]Process, hostNS string) {
+	previous, ok := last[process.Pid]
+	if ok && previous.StartTime == process.StartTime {
+		process.Container = previous.Container
 		return
 	}
-	process.Name = fields[1]
-	process.State = fields[2]
-	process.Ppid, err = strconv.Atoi(fields[3])
+
+	pid := strconv.Itoa(process.Pid)
+
+	b, err := p.config.readFile(p.config.procPath(pid, "cgroup"))
+	if err == nil {
+		process.Container = parseProcCgroup(string(b))
+	}
+
+	ns, err := p.config.readlink(p.config.procPath(pid, "ns", "mnt"))
+	if err == nil && hostNS != "" && ns != hostNS {
+		process.Container.MountNS = ns
+	}
+}
+
+func (p *Processes) readPid(file os.FileInfo) (*Process, error) {
+	dir := p.config.procPath(file.Name())
+
+	process, err := p.readStatPid(filepath.Join(dir, statfile))
 	if err != nil {
-		return
+		return nil, err
 	}
-	process.Pgrp, err = strconv.Atoi(fields[4])
+
+	err = p.readStatmPid(filepath.Join(dir, statmfile), process)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	b, err := p.config.readFile(filepath.Join(dir, cmdline))
+	if err == nil {
+		process.Command = string(bytes.TrimSpace(bytes.Replace(b, []byte{0}, []byte{' '}, -1)))
+	}
+
+	// Les threads noyau n'ont pas de ligne de commande
+	if process.Command == "" {
+		process.Command = "[" + process.Name + "]"
 	}
-	process.Nice, err = strconv.Atoi(fields[20])
+
+	if uid, ok := fileUid(file); ok {
+		process.User = p.lookupUser(uid)
+	}
+
+	return process, nil
+}
+
+// lookupUser returns the name of a user, caching it since processes rarely
+// change their owners.
+func (p *Processes) lookupUser(uid uint32) string {
+	name, ok := p.users[uid]
+	if ok {
+		return name
+	}
+
+	name = strconv.FormatUint(uint64(uid), 10)
+
+	u, err := user.LookupId(name)
+	if err == nil {
+		name = u.Username
+	}
+
+	p.users[uid] = name
+
+	return name
+}
+
+// readStatPid parses /proc/<pid>/stat. The name is between parenthesis and
+// can contain spaces, so the other fields are read after the last ')'.
+func (p *Processes) readStatPid(fileName string) (*Process, error) {
+	b, err := p.config.readFile(fileName)
 	if err != nil {
-		return
+		return nil, err
 	}
-	process.NumThreads, err = strconv.Atoi(fields[21])
+
+	line := string(b)
+	start, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid %s", fileName)
+	}
+
+	// fields[0] est le 3ème champ (state) de proc(5)
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("invalid %s: %d fields", fileName, len(fields))
+	}
+
+	process := &Process{
+		Name:  line[start+1 : end],
+		State: fields[0],
+	}
+
+	process.Pid, err = strconv.Atoi(strings.TrimSpace(line[:start]))
 	if err != nil {
-		return
+		return nil, err
 	}
-	process.Stime, err = strconv.ParseUint(fields[15], 10, 64)
+	process.Ppid, err = strconv.Atoi(fields[1])
 	if err != nil {
-		return
+		return nil, err
 	}
-	process.Utime, err = strconv.ParseUint(fields[16], 10, 64)
+	process.Pgrp, err = strconv.Atoi(fields[2])
 	if err != nil {
-		return
+		return nil, err
+	}
+	process.Utime, err = strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	process.Stime, err = strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	process.Nice, err = strconv.Atoi(fields[16])
+	if err != nil {
+		return nil, err
+	}
+	process.NumThreads, err = strconv.Atoi(fields[17])
+	if err != nil {
+		return nil, err
+	}
+	process.StartTime, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, err
 	}
 
-	p.Processes = append(p.Processes, process)
+	return process, nil
 }
 
-func (p *Processes) Save() error { return nil }
+// readStatmPid reads the virtual memory size and the resident set size,
+// given in pages, from /proc/<pid>/statm.
+func (p *Processes) readStatmPid(fileName string, process *Process) error {
+	file, err := p.config.open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var size, resident kbyte
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	n, err := fmt.Sscanf(scanner.Text(), "%d %d", &size, &resident)
+	err = checkSscanf(statmfile, err, n, 2)
+	if err != nil {
+		return err
+	}
 
-// TODO
-func (n *Processes) MarshalCSV() ([]byte, error)  { return nil, nil }
-func (n *Processes) MarshalJSON() ([]byte, error) { return nil, nil }
+	process.VirtualMem = size * pageSize
+	process.RSS = resident * pageSize
+
+	return nil
+}
+
+// SortBy changes the order of the processes: SortByPid, SortByCPU or
+// SortByMemory.
+func (p *Processes) SortBy(field string) error {
+	switch field {
+	case SortByPid, SortByCPU, SortByMemory:
+		p.sortBy = field
+	default:
+		return fmt.Errorf("invalid process sort '%s'", field)
+	}
+
+	p.sort()
+
+	return nil
+}
+
+func (p *Processes) sort() {
+	sort.Sort(p.Processes)
+
+	switch p.sortBy {
+	case SortByCPU:
+		sort.Stable(processesByCPU{p.Processes})
+	case SortByMemory:
+		sort.Stable(processesByMemory{p.Processes})
+	}
+}
+
+// Top returns at most the n first processes.
+func (p *Processes) Top(n int) []Process {
+	if n <= 0 || n > len(p.Processes) {
+		n = len(p.Processes)
+	}
+
+	return p.Processes[:n]
+}
+
+func (p *Processes) CSVHeader() []string {
+	return []string{"pid", "user", "state", "cpu_pct", "rss_kb", "virtual_kb", "threads",
+		"unit", "container", "pod_uid", "command"}
+}
+
+// MarshalCSV writes a row per process.
+func (p *Processes) MarshalCSV() ([]byte, error) {
+	str := ""
+
+	for _, v := range p.Top(p.config.ProcessTop) {
+		str += strings.Join([]string{
+			strconv.Itoa(v.Pid), csvEscape(v.User), v.State,
+			fmt.Sprintf("%.2f", v.CPU), fmt.Sprintf("%d", v.RSS),
+			fmt.Sprintf("%d", v.VirtualMem), strconv.Itoa(v.NumThreads),
+			csvEscape(v.Container.Unit), v.Container.ID, v.Container.PodUID,
+			csvEscape(v.Command),
+		}, CSVSeparator) + "\n"
+	}
+
+	return []byte(str), nil
+}
+
+func (p *Processes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Top(p.config.ProcessTop))
+}
+
+func (p *Processes) MarshalPrometheus() ([]byte, error) {
+	var b prometheusBuffer
+
+	b.family("processes", prometheusGauge, "Number of processes.")
+	b.sample(float64(len(p.Processes)))
+
+	top := p.Top(p.config.ProcessTop)
+
+	b.family("process_cpu_percent", prometheusGauge, "CPU usage of the top processes in percent.")
+	for _, v := range top {
+		b.sample(v.CPU, v.labels()...)
+	}
+
+	b.family("process_resident_memory_bytes", prometheusGauge, "Resident memory of the top processes.")
+	for _, v := range top {
+		b.sample(float64(v.RSS)*1024, v.labels()...)
+	}
+
+	b.family("process_virtual_memory_bytes", prometheusGauge, "Virtual memory of the top processes.")
+	for _, v := range top {
+		b.sample(float64(v.VirtualMem)*1024, v.labels()...)
+	}
+
+	return b.Bytes(), nil
+}
+
+// labels returns the Prometheus labels of a process.
+func (p *Process) labels() []string {
+	return append([]string{"pid", strconv.Itoa(p.Pid), "name", p.Name, "user", p.User},
+		p.Container.labels()...)
+}
 
 func (p *Processes) String() string {
-	str := "\t========= PORCESS ==========\n"
+	str := "\t========== PROCESSES ==========\n\n"
+	str += fmt.Sprintf("%d processes, sorted by %s\n\n", len(p.Processes), p.sortBy)
+	str += "PID\tUSER\t\tS\t%CPU\tRSS\t\tVIRT\t\tTHR\tUNIT/CONTAINER\t\tCOMMAND\n"
 
-	for _, v := range p.Processes[:20] {
-		if v.IsRunning() {
-			str += fmt.Sprintf("%+v\n", v)
+	for _, v := range p.Top(p.config.ProcessTop) {
+		command := v.Command
+		if len(command) > 60 {
+			command = command[:60]
 		}
+
+		str += fmt.Sprintf("%d\t%-8.8s\t%s\t%.1f\t%-10s\t%-10s\t%d\t%-20.20s\t%s\n",
+			v.Pid, v.User, v.State, v.CPU, v.RSS, v.VirtualMem,
+			v.NumThreads, v.Container.Name(), command)
 	}
 
 	return str
@@ -140,3 +447,15 @@ func (p *Processes) String() string {
 func (p processes) Len() int           { return len(p) }
 func (p processes) Less(i, j int) bool { return p[i].Pid < p[j].Pid }
 func (p processes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type processesByCPU struct{ processes }
+
+func (p processesByCPU) Less(i, j int) bool {
+	return p.processes[i].CPU > p.processes[j].CPU
+}
+
+type processesByMemory struct{ processes }
+
+func (p processesByMemory) Less(i, j int) bool {
+	return p.processes[i].RSS > p.processes[j].RSS
+}
@@ -0,0 +1,69 @@
+package metric
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	prometheusPrefix  = "monitoring_"
+	prometheusCounter = "counter"
+	prometheusGauge   = "gauge"
+)
+
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusBuffer writes metrics in the Prometheus text exposition format.
+// Every family is written by a call to family followed by its samples.
+type prometheusBuffer struct {
+	bytes.Buffer
+	name string
+}
+
+// family starts a metric family. Its name is prefixed by "monitoring_".
+func (p *prometheusBuffer) family(name, kind, help string) {
+	p.name = prometheusPrefix + name
+
+	fmt.Fprintf(p, "# HELP %s %s\n", p.name, help)
+	fmt.Fprintf(p, "# TYPE %s %s\n", p.name, kind)
+}
+
+// sample writes a sample of the current family. The labels are given as
+// name and value pairs.
+func (p *prometheusBuffer) sample(value float64, labels ...string) {
+	p.WriteString(p.name)
+
+	if len(labels) > 0 {
+		p.WriteByte('{')
+
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.WriteByte(',')
+			}
+
+			fmt.Fprintf(p, `%s="%s"`, labels[i], prometheusEscaper.Replace(labels[i+1]))
+		}
+
+		p.WriteByte('}')
+	}
+
+	p.WriteByte(' ')
+	p.WriteString(formatPrometheusValue(value))
+	p.WriteByte('\n')
+}
+
+func formatPrometheusValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,289 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	pressure      = "pressure"
+	psiOutputFile = "psi"
+)
+
+// Ressources et lignes de /proc/pressure
+var (
+	psiResources = []string{"cpu", "memory", "io"}
+	psiKinds     = []string{"some", "full"}
+)
+
+// PSI is the Pressure Stall Information of the kernel: the share of time
+// some or all the tasks were stalled waiting for the CPU, the memory or the
+// I/Os. A kernel without PSI (CONFIG_PSI, psi=0) leaves the resources
+// unavailable instead of failing.
+type PSI struct {
+	saver
+	config                 *Config
+	Resources, lastMeasure []*psiResource
+	time, lastTime         time.Time
+}
+
+type psiResource struct {
+	Name      string     `json:"-"`
+	Available bool       `json:"available"`
+	Some      psiStalls  `json:"some"`
+	Full      *psiStalls `json:"full,omitempty"` // Absent du cpu avant Linux 5.13
+}
+
+type psiStalls struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"` // Microsecondes
+	Stall  float64 `json:"stall"` // % du temps depuis la dernière mesure
+}
+
+func init() {
+	mustRegister("psi", "Pressure stall information (/proc/pressure)", false,
+		func(config *Config) (Metric, error) { return NewPSI(config) })
+}
+
+func NewPSI(config *Config) (*PSI, error) {
+	psi := &PSI{}
+
+	saver, err := newSaver(config, psi, psiOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	psi.saver = *saver
+	psi.config = config
+
+	return psi, nil
+}
+
+func (p *PSI) Update() error {
+	p.lastMeasure, p.lastTime = p.Resources, p.time
+	p.Resources = make([]*psiResource, len(psiResources))
+	p.time = p.config.now()
+
+	for i, name := range psiResources {
+		resource := &psiResource{Name: name}
+		p.Resources[i] = resource
+
+		b, err := p.config.readFile(p.config.procPath(pressure, name))
+		if err != nil {
+			// PSI absent ou désactivé : ENOENT ou EOPNOTSUPP
+			continue
+		}
+
+		err = resource.parse(string(b))
+		if err != nil {
+			return err
+		}
+	}
+
+	p.computeStalls()
+
+	return nil
+}
+
+// parse parses the some and full lines of a /proc/pressure file. Only the
+// memory and io files had a full line before Linux 5.13.
+func (r *psiResource) parse(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		var kind string
+		var stalls psiStalls
+
+		if line == "" {
+			continue
+		}
+
+		n, err := fmt.Sscanf(line, "%s avg10=%f avg60=%f avg300=%f total=%d",
+			&kind, &stalls.Avg10, &stalls.Avg60, &stalls.Avg300, &stalls.Total)
+		err = checkSscanf(pressure+"/"+r.Name, err, n, 5)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case "some":
+			r.Some = stalls
+		case "full":
+			r.Full = &stalls
+		}
+
+		r.Available = true
+	}
+
+	return nil
+}
+
+// computeStalls computes the share of time stalled since the last measure
+// from the total stall times.
+func (p *PSI) computeStalls() {
+	if p.lastTime.IsZero() || len(p.lastMeasure) != len(p.Resources) {
+		return
+	}
+
+	us := float64(p.time.Sub(p.lastTime) / time.Microsecond)
+	if us <= 0 {
+		return
+	}
+
+	for i, resource := range p.Resources {
+		resource.computeStalls(p.lastMeasure[i], us)
+	}
+}
+
+// computeStalls computes the share of the us microseconds elapsed since the
+// last measure the tasks were stalled.
+func (r *psiResource) computeStalls(last *psiResource, us float64) {
+	if !r.Available || last == nil || !last.Available {
+		return
+	}
+
+	stall := func(current, last *psiStalls) {
+		if delta, ok := counterDelta(last.Total, current.Total, counter64); ok {
+			current.Stall = float64(delta) * 100.0 / us
+		}
+
+		if current.Stall > 100.0 {
+			current.Stall = 100.0
+		}
+	}
+
+	stall(&r.Some, &last.Some)
+
+	if r.Full != nil && last.Full != nil {
+		stall(r.Full, last.Full)
+	}
+}
+
+// Available reports whether the kernel exposes any pressure information.
+func (p *PSI) Available() bool {
+	for _, resource := range p.Resources {
+		if resource.Available {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stalls returns the stalls of each of the psiKinds, nil for a missing full
+// line.
+func (r *psiResource) stalls() []*psiStalls {
+	return []*psiStalls{&r.Some, r.Full}
+}
+
+func (p *PSI) CSVHeader() []string {
+	var header []string
+
+	for _, name := range psiResources {
+		for _, kind := range psiKinds {
+			for _, column := range []string{"avg10", "avg60", "avg300", "stall_pct"} {
+				header = append(header, name+"_"+kind+"_"+column)
+			}
+		}
+	}
+
+	return header
+}
+
+func (p *PSI) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, resource := range p.Resources {
+		for _, s := range resource.stalls() {
+			// Colonnes vides plutôt que des zéros trompeurs
+			if !resource.Available || s == nil {
+				values = append(values, "", "", "", "")
+				continue
+			}
+
+			values = append(values, fmt.Sprintf("%.2f", s.Avg10), fmt.Sprintf("%.2f", s.Avg60),
+				fmt.Sprintf("%.2f", s.Avg300), fmt.Sprintf("%.2f", s.Stall))
+		}
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (p *PSI) MarshalJSON() ([]byte, error) {
+	m := make(map[string]*psiResource, len(p.Resources))
+
+	for _, resource := range p.Resources {
+		m[resource.Name] = resource
+	}
+
+	return json.Marshal(m)
+}
+
+func (p *PSI) MarshalPrometheus() ([]byte, error) {
+	var b prometheusBuffer
+
+	b.family("pressure_stalled_seconds_total", prometheusCounter,
+		"Seconds some or all the tasks were stalled on each resource.")
+	for _, resource := range p.Resources {
+		if !resource.Available {
+			continue
+		}
+
+		for i, s := range resource.stalls() {
+			if s != nil {
+				b.sample(float64(s.Total)/1e6, "resource", resource.Name, "kind", psiKinds[i])
+			}
+		}
+	}
+
+	b.family("pressure_average_percent", prometheusGauge,
+		"Share of time stalled on each resource, averaged by the kernel over a window.")
+	for _, resource := range p.Resources {
+		if !resource.Available {
+			continue
+		}
+
+		for i, s := range resource.stalls() {
+			if s == nil {
+				continue
+			}
+
+			b.sample(s.Avg10, "resource", resource.Name, "kind", psiKinds[i], "window", "10s")
+			b.sample(s.Avg60, "resource", resource.Name, "kind", psiKinds[i], "window", "60s")
+			b.sample(s.Avg300, "resource", resource.Name, "kind", psiKinds[i], "window", "300s")
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+func (p *PSI) String() string {
+	str := "\t========== PSI ==========\n\n"
+
+	if !p.Available() {
+		return str + "Pressure stall information unavailable"
+	}
+
+	str += "Resource\tKind\tavg10\tavg60\tavg300\tstall %\n"
+
+	for _, resource := range p.Resources {
+		if !resource.Available {
+			str += fmt.Sprintf("%-8s\tunavailable\n", resource.Name)
+			continue
+		}
+
+		for i, s := range resource.stalls() {
+			if s == nil {
+				str += fmt.Sprintf("%-8s\t%s\t-\t-\t-\t-\n", resource.Name, psiKinds[i])
+				continue
+			}
+
+			str += fmt.Sprintf("%-8s\t%s\t%.2f\t%.2f\t%.2f\t%.2f\n",
+				resource.Name, psiKinds[i], s.Avg10, s.Avg60, s.Avg300, s.Stall)
+		}
+	}
+
+	return str
+}
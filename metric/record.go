@@ -0,0 +1,449 @@
+package metric
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Les archives sont des tar.gz : chaque mesure est un dossier nommé par sa
+// date en nanosecondes depuis l'Epoch, contenant les fichiers lus sous
+// proc/, sys/ et les statistiques des systèmes de fichiers sous statfs/.
+const (
+	archiveProc   = "proc"
+	archiveSys    = "sys"
+	archiveRoot   = "root"
+	archiveStatfs = "statfs"
+	statfsFile    = ".statfs"
+)
+
+// Recorder archives the raw content of the files read by the collectors, so
+// that a Replayer can play the measures back.
+type Recorder struct {
+	config  *Config
+	live    source
+	file    *os.File
+	gzip    *gzip.Writer
+	tar     *tar.Writer
+	entries map[string]*archiveEntry
+}
+
+type archiveEntry struct {
+	header  *tar.Header
+	content []byte
+}
+
+// NewRecorder creates the archive fileName and makes the collectors created
+// with config record what they read in it.
+func NewRecorder(config *Config, fileName string) (*Recorder, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+
+	r := &Recorder{
+		config:  config,
+		live:    config.getSource(),
+		file:    file,
+		gzip:    gz,
+		tar:     tar.NewWriter(gz),
+		entries: make(map[string]*archiveEntry),
+	}
+
+	config.source = r
+
+	return r, nil
+}
+
+func (r *Recorder) Open(name string) (io.ReadCloser, error) {
+	file, err := r.live.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r.entries[r.config.archiveName(name)] = &archiveEntry{
+		header:  &tar.Header{Typeflag: tar.TypeReg, Mode: 0444, Size: int64(len(b))},
+		content: b,
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (r *Recorder) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := r.live.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := r.config.archiveName(name)
+
+	for _, info := range infos {
+		entryName := path.Join(dir, info.Name())
+
+		// Le contenu d'un fichier déjà lu vaut entrée de dossier
+		if _, ok := r.entries[entryName]; ok {
+			continue
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			continue
+		}
+
+		// Les liens de /sys/class/hwmon, ... sont archivés comme tels
+		if info.Mode()&os.ModeSymlink != 0 {
+			header.Linkname, err = r.live.Readlink(filepath.Join(name, info.Name()))
+			if err != nil {
+				continue
+			}
+		} else if !info.IsDir() {
+			header.Typeflag = tar.TypeReg
+			header.Size = 0
+		}
+
+		r.entries[entryName] = &archiveEntry{header: header}
+	}
+
+	return infos, nil
+}
+
+func (r *Recorder) Readlink(name string) (string, error) {
+	link, err := r.live.Readlink(name)
+	if err != nil {
+		return "", err
+	}
+
+	r.entries[r.config.archiveName(name)] = &archiveEntry{
+		header: &tar.Header{Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: link},
+	}
+
+	return link, nil
+}
+
+func (r *Recorder) Statfs(path string, stat *syscall.Statfs_t) error {
+	err := r.live.Statfs(path, stat)
+	if err != nil {
+		return err
+	}
+
+	b := []byte(fmt.Sprintf("%d %d %d %d %d %d %d\n", stat.Blocks, stat.Bfree,
+		stat.Bavail, stat.Bsize, stat.Frsize, stat.Files, stat.Ffree))
+
+	r.entries[statfsName(path)] = &archiveEntry{
+		header:  &tar.Header{Typeflag: tar.TypeReg, Mode: 0444, Size: int64(len(b))},
+		content: b,
+	}
+
+	return nil
+}
+
+func (r *Recorder) Now() time.Time {
+	return r.live.Now()
+}
+
+// Snapshot archives the files read since the last snapshot as a measure
+// taken at t.
+func (r *Recorder) Snapshot(t time.Time) error {
+	group := strconv.FormatInt(t.UnixNano(), 10)
+
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := r.entries[name]
+		entry.header.Name = group + "/" + name
+		entry.header.ModTime = t
+
+		if entry.header.Typeflag == tar.TypeDir {
+			entry.header.Name += "/"
+		}
+
+		err := r.tar.WriteHeader(entry.header)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.tar.Write(entry.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.entries = make(map[string]*archiveEntry)
+
+	// L'archive reste lisible jusqu'à la dernière mesure en cas d'arrêt brutal
+	err := r.tar.Flush()
+	if err != nil {
+		return err
+	}
+
+	return r.gzip.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.config.source = r.live
+
+	err := r.tar.Close()
+	if err == nil {
+		err = r.gzip.Close()
+	}
+
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// Replayer plays back an archive written by a Recorder: the collectors
+// created with its config read the archived files instead of the system.
+type Replayer struct {
+	config         *Config
+	file           *os.File
+	gzip           *gzip.Reader
+	tar            *tar.Reader
+	pending        *archiveEntry
+	time, nextTime time.Time
+	files          map[string][]byte
+	links          map[string]string
+	dirs           map[string][]os.FileInfo
+}
+
+func NewReplayer(config *Config, fileName string) (*Replayer, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid archive '%s': %s", fileName, err)
+	}
+
+	r := &Replayer{
+		config: config,
+		file:   file,
+		gzip:   gz,
+		tar:    tar.NewReader(gz),
+	}
+
+	err = r.readEntry()
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("invalid archive '%s': %s", fileName, err)
+	}
+
+	if r.pending == nil {
+		r.Close()
+		return nil, fmt.Errorf("empty archive '%s'", fileName)
+	}
+
+	r.nextTime, err = entryTime(r.pending.header.Name)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	config.source = r
+
+	return r, nil
+}
+
+// readEntry reads the next entry of the archive in pending, nil at the end.
+func (r *Replayer) readEntry() error {
+	header, err := r.tar.Next()
+	if err == io.EOF {
+		r.pending = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(r.tar)
+	if err != nil {
+		return err
+	}
+
+	r.pending = &archiveEntry{header: header, content: content}
+
+	return nil
+}
+
+// Next loads the next measure of the archive. It returns io.EOF after the
+// last one.
+func (r *Replayer) Next() error {
+	if r.pending == nil {
+		return io.EOF
+	}
+
+	group := entryGroup(r.pending.header.Name)
+
+	r.time = r.nextTime
+	r.files = make(map[string][]byte)
+	r.links = make(map[string]string)
+	r.dirs = make(map[string][]os.FileInfo)
+
+	for r.pending != nil && entryGroup(r.pending.header.Name) == group {
+		header := r.pending.header
+		name := strings.TrimSuffix(strings.TrimPrefix(header.Name, group+"/"), "/")
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			r.links[name] = header.Linkname
+		default:
+			r.files[name] = r.pending.content
+		}
+
+		dir := path.Dir(name)
+		r.dirs[dir] = append(r.dirs[dir], header.FileInfo())
+
+		err := r.readEntry()
+		if err != nil {
+			return err
+		}
+	}
+
+	r.nextTime = time.Time{}
+
+	if r.pending != nil {
+		var err error
+
+		r.nextTime, err = entryTime(r.pending.header.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delay returns the time to wait before the next measure to replay the
+// archive at the given speed: 1 is the original speed, 0 as fast as
+// possible.
+func (r *Replayer) Delay(speed float64) time.Duration {
+	if speed <= 0 || r.nextTime.IsZero() || r.time.IsZero() {
+		return 0
+	}
+
+	return time.Duration(float64(r.nextTime.Sub(r.time)) / speed)
+}
+
+func (r *Replayer) Open(name string) (io.ReadCloser, error) {
+	b, ok := r.files[r.config.archiveName(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (r *Replayer) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, ok := r.dirs[r.config.archiveName(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	return infos, nil
+}
+
+func (r *Replayer) Readlink(name string) (string, error) {
+	link, ok := r.links[r.config.archiveName(name)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+
+	return link, nil
+}
+
+func (r *Replayer) Statfs(path string, stat *syscall.Statfs_t) error {
+	b, ok := r.files[statfsName(path)]
+	if !ok {
+		return &os.PathError{Op: "statfs", Path: path, Err: os.ErrNotExist}
+	}
+
+	n, err := fmt.Sscanf(string(b), "%d %d %d %d %d %d %d", &stat.Blocks, &stat.Bfree,
+		&stat.Bavail, &stat.Bsize, &stat.Frsize, &stat.Files, &stat.Ffree)
+
+	return checkSscanf(statfsFile, err, n, 7)
+}
+
+// Now returns the time the current measure was recorded at.
+func (r *Replayer) Now() time.Time {
+	return r.time
+}
+
+func (r *Replayer) Close() error {
+	if r.config.source == r {
+		r.config.source = nil
+	}
+
+	r.gzip.Close()
+
+	return r.file.Close()
+}
+
+// archiveName returns the name of a file in an archive. The files are
+// relative to the proc and sys roots, so that an archive recorded in a
+// container can be replayed anywhere.
+func (c *Config) archiveName(name string) string {
+	roots := []struct{ prefix, dir string }{
+		{archiveProc, c.procPath()},
+		{archiveSys, c.sysPath()},
+	}
+
+	for _, root := range roots {
+		rel, err := filepath.Rel(root.dir, name)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return path.Join(root.prefix, filepath.ToSlash(rel))
+		}
+	}
+
+	return path.Join(archiveRoot, filepath.ToSlash(name))
+}
+
+func statfsName(mountPoint string) string {
+	return path.Join(archiveStatfs, filepath.ToSlash(mountPoint), statfsFile)
+}
+
+func entryGroup(name string) string {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return name
+	}
+
+	return name[:i]
+}
+
+func entryTime(name string) (time.Time, error) {
+	ns, err := strconv.ParseInt(entryGroup(name), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid archive entry '%s'", name)
+	}
+
+	return time.Unix(0, ns), nil
+}
@@ -0,0 +1,112 @@
+package metric
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRecordReplay records the fixture trees, replays them and compares the
+// output of collectors reading files, directories and links.
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "record.tar.gz")
+
+	marshal := func(config *Config) [][]byte {
+		sensors, err := NewSensors(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		netstat, err := NewNetstat(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var outputs [][]byte
+
+		for _, metric := range []Metric{sensors, netstat} {
+			err = metric.Update()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(metric)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outputs = append(outputs, b)
+		}
+
+		return outputs
+	}
+
+	config := &Config{ProcRoot: "testdata/proc", SysRoot: "testdata/sys", Mode: ModeWEB}
+
+	recorder, err := NewRecorder(config, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := marshal(config)
+	if !bytes.Contains(recorded[0], []byte("coretemp")) {
+		t.Fatalf("sensors of the fixtures = %s, want coretemp", recorded[0])
+	}
+
+	err = recorder.Snapshot(time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = recorder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejouée sous d'autres racines, l'archive ne lit pas les fixtures
+	replayConfig := &Config{ProcRoot: "/nonexistent/proc", SysRoot: "/nonexistent/sys", Mode: ModeWEB}
+
+	replayer, err := NewReplayer(replayConfig, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+
+	err = replayer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := marshal(replayConfig)
+
+	for i := range recorded {
+		if !bytes.Equal(recorded[i], replayed[i]) {
+			t.Errorf("replayed %s, want %s", replayed[i], recorded[i])
+		}
+	}
+
+	infos, err := replayConfig.readDir(replayConfig.sysPath("class", "hwmon"))
+	if err != nil || len(infos) != 1 || infos[0].Mode()&os.ModeSymlink == 0 {
+		t.Errorf("replayed /sys/class/hwmon = %v, %v, want the hwmon0 link", infos, err)
+	}
+
+	link, err := replayConfig.readlink(replayConfig.sysPath("class", "hwmon", "hwmon0"))
+	if want := "../../devices/platform/coretemp.0/hwmon/hwmon0"; link != want || err != nil {
+		t.Errorf("replayed hwmon0 link = %q, %v, want %q", link, err, want)
+	}
+
+	if replayer.Next() != io.EOF {
+		t.Errorf("replayer.Next() after the last measure, want io.EOF")
+	}
+}
@@ -0,0 +1,160 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Constructor creates a Metric from the configuration.
+type Constructor func(config *Config) (Metric, error)
+
+// Collector describes a Metric which can be selected with -metrics.
+type Collector struct {
+	Name        string
+	Description string
+	Enabled     bool // Monitored when -metrics is empty
+	New         Constructor
+}
+
+// displayOrder is the order the collectors of this package are listed and
+// displayed in, related ones side by side. The collectors registered by other
+// packages follow them, in registration order.
+var displayOrder = []string{
+	"cpu", "load", "mem", "vmstat", "psi", "sensors", "disk", "fs",
+	"net", "netstat", "sockets", "cgroup", "proc",
+}
+
+var registry = struct {
+	sync.RWMutex
+	collectors []Collector
+}{}
+
+// Register adds a collector to the ones -metrics can select. The collectors
+// of this package register themselves in their init function; code embedding
+// the package can register its own Metric before calling NewConfig.
+func Register(name, description string, enabled bool, constructor Constructor) error {
+	if name == "" || strings.Contains(name, ",") {
+		return fmt.Errorf("invalid metric name '%s'", name)
+	}
+
+	if constructor == nil {
+		return fmt.Errorf("metric '%s' has no constructor", name)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, c := range registry.collectors {
+		if c.Name == name {
+			return fmt.Errorf("metric '%s' is already registered", name)
+		}
+	}
+
+	registry.collectors = append(registry.collectors, Collector{
+		Name:        name,
+		Description: description,
+		Enabled:     enabled,
+		New:         constructor,
+	})
+
+	return nil
+}
+
+// mustRegister registers a collector of this package.
+func mustRegister(name, description string, enabled bool, constructor Constructor) {
+	err := Register(name, description, enabled, constructor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Collectors returns the registered collectors, in display order.
+func Collectors() []Collector {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	collectors := make([]Collector, len(registry.collectors))
+	copy(collectors, registry.collectors)
+
+	sort.SliceStable(collectors, func(i, j int) bool {
+		return displayRank(collectors[i].Name) < displayRank(collectors[j].Name)
+	})
+
+	return collectors
+}
+
+// displayRank returns the position of a collector in displayOrder, or its
+// length if it is not there.
+func displayRank(name string) int {
+	for i, n := range displayOrder {
+		if n == name {
+			return i
+		}
+	}
+
+	return len(displayOrder)
+}
+
+// Lookup returns the collector registered under name.
+func Lookup(name string) (Collector, bool) {
+	for _, c := range Collectors() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return Collector{}, false
+}
+
+// ParseMetrics returns the names of the metrics selected by a comma
+// separated list, or the enabled collectors if the list is empty, in display
+// order.
+func ParseMetrics(list string) ([]string, error) {
+	var names []string
+
+	if strings.TrimSpace(list) == "" {
+		for _, c := range Collectors() {
+			if c.Enabled {
+				names = append(names, c.Name)
+			}
+		}
+
+		return names, nil
+	}
+
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+
+		if _, ok := Lookup(name); !ok {
+			return nil, fmt.Errorf("invalid metric '%s'", name)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("metric '%s' selected twice", name)
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return displayRank(names[i]) < displayRank(names[j])
+	})
+
+	return names, nil
+}
+
+// metricNames returns the names of the registered collectors.
+func metricNames() []string {
+	var names []string
+
+	for _, c := range Collectors() {
+		names = append(names, c.Name)
+	}
+
+	return names
+}
@@ -0,0 +1,435 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sensorsOutputFile = "sensors"
+	hwmon             = "hwmon"
+	thermalZone       = "thermal_zone"
+)
+
+// Types de capteurs de hwmon
+const (
+	SensorTemperature = "temp"
+	SensorFan         = "fan"
+	SensorVoltage     = "in"
+)
+
+var (
+	sensorInput = regexp.MustCompile(`^(temp|fan|in)([0-9]+)_input$`)
+
+	// Unités et diviseurs des valeurs de sysfs (millidegrés, millivolts)
+	sensorUnits = map[string]struct {
+		unit, csv string
+		divisor   float64
+	}{
+		SensorTemperature: {"°C", "c", 1000},
+		SensorFan:         {"RPM", "rpm", 1},
+		SensorVoltage:     {"V", "v", 1000},
+	}
+)
+
+// Sensors reports the hardware sensors of hwmon, temperatures, fans and
+// voltages, and the thermal zones. A reading at or above the critical
+// threshold reported by the kernel is flagged.
+type Sensors struct {
+	saver
+	config   *Config
+	Readings []*sensorReading
+}
+
+type sensorReading struct {
+	Chip     string  `json:"chip"`   // coretemp, nvme, ... ou thermal
+	Sensor   string  `json:"sensor"` // temp1, fan2, zone0, ...
+	Kind     string  `json:"kind"`
+	Label    string  `json:"label,omitempty"`
+	Value    float64 `json:"value"`
+	Max      float64 `json:"max,omitempty"`
+	Crit     float64 `json:"crit,omitempty"`
+	High     bool    `json:"high"`     // Au-dessus de Max
+	Critical bool    `json:"critical"` // Au-dessus de Crit
+	index    int     // Numéro de la zone thermique
+}
+
+func init() {
+	mustRegister("sensors", "Temperatures, fans and voltages (/sys/class/hwmon, thermal)", false,
+		func(config *Config) (Metric, error) { return NewSensors(config) })
+}
+
+func NewSensors(config *Config) (*Sensors, error) {
+	sensors := &Sensors{}
+
+	saver, err := newSaver(config, sensors, sensorsOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sensors.saver = *saver
+	sensors.config = config
+
+	return sensors, nil
+}
+
+func (s *Sensors) Update() error {
+	s.Readings = nil
+
+	err := s.readHwmon()
+	if err != nil {
+		return err
+	}
+
+	return s.readThermalZones()
+}
+
+// readHwmon reads the chips of /sys/class/hwmon. Their entries are links, so
+// they are not filtered on IsDir. Without hwmon there is no reading.
+func (s *Sensors) readHwmon() error {
+	dir := s.config.sysPath("class", hwmon)
+
+	files, err := s.config.readDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	chips := make(map[string]bool)
+
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), hwmon) {
+			continue
+		}
+
+		chipDir := filepath.Join(dir, file.Name())
+		name := s.readString(filepath.Join(chipDir, "name"))
+
+		// Les anciens pilotes exposent leurs capteurs dans device/
+		inputs, err := s.inputs(chipDir)
+		if err == nil && len(inputs) == 0 {
+			chipDir = filepath.Join(chipDir, "device")
+			inputs, err = s.inputs(chipDir)
+
+			if name == "" {
+				name = s.readString(filepath.Join(chipDir, "name"))
+			}
+		}
+		if err != nil {
+			continue
+		}
+
+		if name == "" {
+			name = file.Name()
+		}
+
+		// Deux puces du même pilote (nvme, ...) se distinguent par leur numéro
+		if chips[name] {
+			name += "-" + strings.TrimPrefix(file.Name(), hwmon)
+		}
+		chips[name] = true
+
+		for _, input := range inputs {
+			reading, err := s.readInput(chipDir, name, input)
+			if err != nil {
+				// Capteur absent ou en erreur (EIO, ENODATA)
+				continue
+			}
+
+			s.Readings = append(s.Readings, reading)
+		}
+	}
+
+	return nil
+}
+
+// inputs returns the *_input files of a chip, sorted by kind and index.
+func (s *Sensors) inputs(chipDir string) ([]string, error) {
+	files, err := s.config.readDir(chipDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []string
+
+	for _, file := range files {
+		if sensorInput.MatchString(file.Name()) {
+			inputs = append(inputs, file.Name())
+		}
+	}
+
+	sort.Slice(inputs, func(i, j int) bool {
+		a, b := sensorInput.FindStringSubmatch(inputs[i]), sensorInput.FindStringSubmatch(inputs[j])
+		if a[1] != b[1] {
+			return a[1] > b[1] // temp, in puis fan
+		}
+
+		ai, _ := strconv.Atoi(a[2])
+		bi, _ := strconv.Atoi(b[2])
+
+		return ai < bi
+	})
+
+	return inputs, nil
+}
+
+// readInput reads a sensor and its label and thresholds: temp1_input,
+// temp1_label, temp1_max and temp1_crit.
+func (s *Sensors) readInput(chipDir, chip, input string) (*sensorReading, error) {
+	m := sensorInput.FindStringSubmatch(input)
+	sensor := strings.TrimSuffix(input, "_input")
+	divisor := sensorUnits[m[1]].divisor
+
+	value, err := s.readValue(filepath.Join(chipDir, input), divisor)
+	if err != nil {
+		return nil, err
+	}
+
+	reading := &sensorReading{
+		Chip:   chip,
+		Sensor: sensor,
+		Kind:   m[1],
+		Label:  s.readString(filepath.Join(chipDir, sensor+"_label")),
+		Value:  value,
+	}
+
+	reading.Max, _ = s.readValue(filepath.Join(chipDir, sensor+"_max"), divisor)
+	reading.Crit, _ = s.readValue(filepath.Join(chipDir, sensor+"_crit"), divisor)
+	reading.flag()
+
+	return reading, nil
+}
+
+// readThermalZones reads the thermal zones of /sys/class/thermal. The critical
+// threshold is the trip point of type critical, the max one the trip point of
+// type hot.
+func (s *Sensors) readThermalZones() error {
+	dir := s.config.sysPath("class", "thermal")
+
+	files, err := s.config.readDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var zones []*sensorReading
+
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), thermalZone) {
+			continue
+		}
+
+		zoneDir := filepath.Join(dir, file.Name())
+
+		value, err := s.readValue(filepath.Join(zoneDir, "temp"), 1000)
+		if err != nil {
+			// Zone désactivée
+			continue
+		}
+
+		zone := &sensorReading{
+			Chip:   "thermal",
+			Sensor: "zone" + strings.TrimPrefix(file.Name(), thermalZone),
+			Kind:   SensorTemperature,
+			Label:  s.readString(filepath.Join(zoneDir, "type")),
+			Value:  value,
+		}
+		zone.index, _ = strconv.Atoi(strings.TrimPrefix(file.Name(), thermalZone))
+
+		for i := 0; ; i++ {
+			trip := filepath.Join(zoneDir, fmt.Sprintf("trip_point_%d_", i))
+
+			kind := s.readString(trip + "type")
+			if kind == "" {
+				break
+			}
+
+			temp, err := s.readValue(trip+"temp", 1000)
+			if err != nil {
+				continue
+			}
+
+			switch kind {
+			case "critical":
+				zone.Crit = temp
+			case "hot":
+				zone.Max = temp
+			}
+		}
+
+		zone.flag()
+		zones = append(zones, zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return zones[i].index < zones[j].index })
+	s.Readings = append(s.Readings, zones...)
+
+	return nil
+}
+
+func (s *Sensors) readString(fileName string) string {
+	b, err := s.config.readFile(fileName)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func (s *Sensors) readValue(fileName string, divisor float64) (float64, error) {
+	b, err := s.config.readFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", fileName, err)
+	}
+
+	return value / divisor, nil
+}
+
+// flag flags a reading at or above its thresholds. Some drivers report 0
+// when they have none.
+func (r *sensorReading) flag() {
+	r.High = r.Max > 0 && r.Value >= r.Max
+	r.Critical = r.Crit > 0 && r.Value >= r.Crit
+}
+
+// Name returns the name of the reading in the outputs: coretemp_temp1.
+func (r *sensorReading) Name() string {
+	return r.Chip + "_" + r.Sensor
+}
+
+// Unit returns the unit of the reading: °C, RPM or V.
+func (r *sensorReading) Unit() string {
+	return sensorUnits[r.Kind].unit
+}
+
+// Critical returns the readings at or above their critical threshold.
+func (s *Sensors) Critical() []*sensorReading {
+	var critical []*sensorReading
+
+	for _, r := range s.Readings {
+		if r.Critical {
+			critical = append(critical, r)
+		}
+	}
+
+	return critical
+}
+
+func (s *Sensors) CSVHeader() []string {
+	header := []string{"critical"}
+
+	for _, r := range s.Readings {
+		header = append(header, r.Name()+"_"+sensorUnits[r.Kind].csv)
+	}
+
+	return header
+}
+
+func (s *Sensors) MarshalCSV() ([]byte, error) {
+	values := []string{strconv.Itoa(len(s.Critical()))}
+
+	for _, r := range s.Readings {
+		values = append(values, strconv.FormatFloat(r.Value, 'f', -1, 64))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (s *Sensors) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Readings)
+}
+
+func (s *Sensors) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	families := []struct {
+		kind, name, help string
+	}{
+		{SensorTemperature, "sensors_temperature_celsius", "Temperature of each sensor."},
+		{SensorFan, "sensors_fan_rpm", "Speed of each fan."},
+		{SensorVoltage, "sensors_voltage_volts", "Voltage of each sensor."},
+	}
+
+	for _, family := range families {
+		p.family(family.name, prometheusGauge, family.help)
+		for _, r := range s.Readings {
+			if r.Kind == family.kind {
+				p.sample(r.Value, r.labels()...)
+			}
+		}
+	}
+
+	p.family("sensors_max", prometheusGauge, "Max threshold of each sensor, in the unit of the sensor.")
+	for _, r := range s.Readings {
+		if r.Max > 0 {
+			p.sample(r.Max, r.labels()...)
+		}
+	}
+
+	p.family("sensors_crit", prometheusGauge, "Critical threshold of each sensor, in the unit of the sensor.")
+	for _, r := range s.Readings {
+		if r.Crit > 0 {
+			p.sample(r.Crit, r.labels()...)
+		}
+	}
+
+	p.family("sensors_critical", prometheusGauge, "1 if the sensor is at or above its critical threshold.")
+	for _, r := range s.Readings {
+		if r.Crit > 0 {
+			critical := 0.0
+			if r.Critical {
+				critical = 1
+			}
+
+			p.sample(critical, r.labels()...)
+		}
+	}
+
+	return p.Bytes(), nil
+}
+
+// labels returns the Prometheus labels of a reading.
+func (r *sensorReading) labels() []string {
+	return []string{"chip", r.Chip, "sensor", r.Sensor, "label", r.Label}
+}
+
+// threshold formats a threshold, - if the sensor has none.
+func (r *sensorReading) threshold(value float64) string {
+	if value <= 0 {
+		return "-"
+	}
+
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (s *Sensors) String() string {
+	str := "\t========== SENSORS ==========\n\n"
+
+	if len(s.Readings) == 0 {
+		return str + "No sensor"
+	}
+
+	str += "Sensor\t\t\tLabel\t\tValue\t\tMax\tCrit\n"
+
+	for _, r := range s.Readings {
+		flag := ""
+		if r.Critical {
+			flag = "CRITICAL"
+		} else if r.High {
+			flag = "HIGH"
+		}
+
+		str += fmt.Sprintf("%-20.20s\t%-12.12s\t%8.2f %-3s\t%s\t%s\t%s\n",
+			r.Name(), r.Label, r.Value, r.Unit(), r.threshold(r.Max), r.threshold(r.Crit), flag)
+	}
+
+	return str
+}
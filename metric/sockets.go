@@ -0,0 +1,515 @@
+package metric
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const (
+	socketsOutputFile = "sockets"
+	topPeers          = 10
+	socketPrefix      = "socket:["
+)
+
+// États TCP de include/net/tcp_states.h
+var tcpStates = []string{
+	"ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT",
+	"CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING", "NEW_SYN_RECV",
+}
+
+const (
+	tcpListen       = 0x0A
+	unixAcceptConn  = 0x10000 // __SO_ACCEPTCON : socket unix en écoute
+	socketsTCP      = "tcp"
+	socketsUDP      = "udp"
+	socketsUnix     = "unix"
+	nbSocketColumns = 10
+)
+
+// nativeEndian is the byte order of the host, the one of the addresses of
+// /proc/net/tcp.
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}()
+
+// Sockets summarizes the sockets of the system: the TCP connections by
+// state, the listening sockets with their process and the remote peers with
+// the most connections.
+type Sockets struct {
+	saver
+	config    *Config
+	States    map[string]int // Connexions TCP par état
+	UDP       int
+	Unix      int
+	Listening []*listeningSocket
+	Peers     []*socketPeer
+	inodes    map[uint64]bool // Sockets en écoute lors du dernier parcours de /proc/<pid>/fd
+	owners    map[uint64]socketOwner
+}
+
+type listeningSocket struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port,omitempty"`
+	Pid      int    `json:"pid,omitempty"`
+	Process  string `json:"process,omitempty"`
+	inode    uint64
+}
+
+type socketOwner struct {
+	pid     int
+	process string
+}
+
+type socketPeer struct {
+	Address     string `json:"address"`
+	Connections int    `json:"connections"`
+}
+
+func init() {
+	mustRegister("sockets", "TCP states, listening ports and peers (/proc/net/tcp, udp, unix)", false,
+		func(config *Config) (Metric, error) { return NewSockets(config) })
+}
+
+func NewSockets(config *Config) (*Sockets, error) {
+	sockets := &Sockets{}
+
+	saver, err := newSaver(config, sockets, socketsOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets.saver = *saver
+	sockets.config = config
+	sockets.States = make(map[string]int)
+
+	return sockets, nil
+}
+
+func (s *Sockets) Update() error {
+	s.States = make(map[string]int, len(tcpStates))
+	s.UDP, s.Unix = 0, 0
+	s.Listening, s.Peers = nil, nil
+
+	peers := make(map[string]int)
+
+	for _, name := range []string{"tcp", "tcp6"} {
+		err := s.readInet(name, func(local, remote *net.TCPAddr, state int, inode uint64) {
+			if state < 1 || state > len(tcpStates) {
+				return
+			}
+
+			s.States[tcpStates[state-1]]++
+
+			if state == tcpListen {
+				s.listen(socketsTCP, local, inode)
+			} else if !remote.IP.IsUnspecified() {
+				peers[remote.IP.String()]++
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{"udp", "udp6"} {
+		err := s.readInet(name, func(local, remote *net.TCPAddr, state int, inode uint64) {
+			s.UDP++
+
+			// Un socket UDP non connecté reçoit de n'importe qui
+			if remote.Port == 0 {
+				s.listen(socketsUDP, local, inode)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.readUnix()
+	if err != nil {
+		return err
+	}
+
+	s.findProcesses()
+	s.sortListening()
+	s.topPeers(peers)
+
+	return nil
+}
+
+func (s *Sockets) listen(protocol string, local *net.TCPAddr, inode uint64) {
+	s.Listening = append(s.Listening, &listeningSocket{
+		Protocol: protocol,
+		Address:  local.IP.String(),
+		Port:     local.Port,
+		inode:    inode,
+	})
+}
+
+// readInet parses /proc/net/tcp, tcp6, udp or udp6. A missing file (IPv6
+// disabled) has no socket.
+func (s *Sockets) readInet(name string, socket func(local, remote *net.TCPAddr, state int, inode uint64)) error {
+	fileName := s.config.procPath("net", name)
+
+	b, err := s.config.readFile(fileName)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(string(b), "\n")
+
+	// La première ligne est l'en-tête
+	for i := 1; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		if len(fields) < nbSocketColumns {
+			continue
+		}
+
+		local, err := parseSocketAddress(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		remote, err := parseSocketAddress(fields[2])
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		socket(local, remote, int(state), inode)
+	}
+
+	return nil
+}
+
+// parseSocketAddress parses an address of /proc/net/tcp: "0100007F:0050" is
+// 127.0.0.1:80. The address is made of 32 bits words in host byte order.
+func parseSocketAddress(str string) (*net.TCPAddr, error) {
+	i := strings.IndexByte(str, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid address '%s'", str)
+	}
+
+	ip, err := hex.DecodeString(str[:i])
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return nil, fmt.Errorf("invalid address '%s'", str)
+	}
+
+	port, err := strconv.ParseUint(str[i+1:], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", str)
+	}
+
+	// Chaque mot est écrit dans l'ordre de l'hôte, net.IP est gros-boutiste
+	for j := 0; j < len(ip); j += 4 {
+		binary.BigEndian.PutUint32(ip[j:], nativeEndian.Uint32(ip[j:]))
+	}
+
+	return &net.TCPAddr{IP: net.IP(ip), Port: int(port)}, nil
+}
+
+// readUnix parses /proc/net/unix. The listening sockets are reported with
+// their path.
+func (s *Sockets) readUnix() error {
+	fileName := s.config.procPath("net", "unix")
+
+	b, err := s.config.readFile(fileName)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(string(b), "\n")
+
+	for i := 1; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		if len(fields) < 7 {
+			continue
+		}
+
+		s.Unix++
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		if flags&unixAcceptConn == 0 {
+			continue
+		}
+
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %s", fileName, i+1, err)
+		}
+
+		path := ""
+		if len(fields) > 7 {
+			path = fields[7]
+		}
+
+		s.Listening = append(s.Listening, &listeningSocket{
+			Protocol: socketsUnix,
+			Address:  path,
+			inode:    inode,
+		})
+	}
+
+	return nil
+}
+
+// findProcesses finds the process owning each listening socket, matching the
+// inode of the socket with the links of /proc/<pid>/fd. Reading the fds of
+// every process is expensive, so they are only read again when the listening
+// sockets change. The processes of other users are out of reach without
+// privileges.
+func (s *Sockets) findProcesses() {
+	inodes := make(map[uint64]bool, len(s.Listening))
+	for _, socket := range s.Listening {
+		inodes[socket.inode] = true
+	}
+
+	if !sameInodes(inodes, s.inodes) {
+		s.inodes = inodes
+		s.owners = s.scanOwners(inodes)
+	}
+
+	for _, socket := range s.Listening {
+		if owner, ok := s.owners[socket.inode]; ok {
+			socket.Pid, socket.Process = owner.pid, owner.process
+		}
+	}
+}
+
+// scanOwners returns the process owning each of the inodes, from the links
+// of /proc/<pid>/fd.
+func (s *Sockets) scanOwners(inodes map[uint64]bool) map[uint64]socketOwner {
+	owners := make(map[uint64]socketOwner)
+
+	if len(inodes) == 0 {
+		return owners
+	}
+
+	pids, err := s.config.pidDirs()
+	if err != nil {
+		return owners
+	}
+
+	for _, dir := range pids {
+		fdDir := s.config.procPath(dir.Name(), "fd")
+
+		fds, err := s.config.readDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var process string
+
+		for _, fd := range fds {
+			link, err := s.config.readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, socketPrefix) {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[len(socketPrefix):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if _, ok := owners[inode]; !inodes[inode] || ok {
+				continue
+			}
+
+			if process == "" {
+				b, _ := s.config.readFile(s.config.procPath(dir.Name(), "comm"))
+				process = strings.TrimSpace(string(b))
+			}
+
+			pid, _ := strconv.Atoi(dir.Name())
+			owners[inode] = socketOwner{pid: pid, process: process}
+		}
+	}
+
+	return owners
+}
+
+// sameInodes reports whether a and b hold the same inodes.
+func sameInodes(a, b map[uint64]bool) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return false
+	}
+
+	for inode := range a {
+		if !b[inode] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortListening sorts the listening sockets by protocol, port and address.
+func (s *Sockets) sortListening() {
+	sort.Slice(s.Listening, func(i, j int) bool {
+		a, b := s.Listening[i], s.Listening[j]
+
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+
+		return a.Address < b.Address
+	})
+}
+
+// topPeers keeps the remote addresses with the most TCP connections.
+func (s *Sockets) topPeers(peers map[string]int) {
+	for address, connections := range peers {
+		s.Peers = append(s.Peers, &socketPeer{Address: address, Connections: connections})
+	}
+
+	sort.Slice(s.Peers, func(i, j int) bool {
+		if s.Peers[i].Connections != s.Peers[j].Connections {
+			return s.Peers[i].Connections > s.Peers[j].Connections
+		}
+
+		return s.Peers[i].Address < s.Peers[j].Address
+	})
+
+	if len(s.Peers) > topPeers {
+		s.Peers = s.Peers[:topPeers]
+	}
+}
+
+func (s *Sockets) CSVHeader() []string {
+	var header []string
+
+	for _, state := range tcpStates {
+		header = append(header, "tcp_"+strings.ToLower(state))
+	}
+
+	return append(header, "udp", "unix", "listening")
+}
+
+func (s *Sockets) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, state := range tcpStates {
+		values = append(values, strconv.Itoa(s.States[state]))
+	}
+
+	values = append(values, strconv.Itoa(s.UDP), strconv.Itoa(s.Unix), strconv.Itoa(len(s.Listening)))
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (s *Sockets) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"tcp":       s.States,
+		"udp":       s.UDP,
+		"unix":      s.Unix,
+		"listening": s.Listening,
+		"peers":     s.Peers,
+	})
+}
+
+func (s *Sockets) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	p.family("sockets_tcp", prometheusGauge, "TCP connections by state.")
+	for _, state := range tcpStates {
+		p.sample(float64(s.States[state]), "state", state)
+	}
+
+	p.family("sockets_udp", prometheusGauge, "UDP sockets.")
+	p.sample(float64(s.UDP))
+
+	p.family("sockets_unix", prometheusGauge, "Unix sockets.")
+	p.sample(float64(s.Unix))
+
+	// Plusieurs sockets partagent les mêmes labels (SO_REUSEPORT, sockets unix
+	// sans nom) : ils sont comptés dans une seule série
+	type listeningLabels struct {
+		protocol, address, port, process string
+	}
+
+	var order []listeningLabels
+	counts := make(map[listeningLabels]int)
+
+	for _, l := range s.Listening {
+		labels := listeningLabels{l.Protocol, l.Address, strconv.Itoa(l.Port), l.Process}
+		if counts[labels] == 0 {
+			order = append(order, labels)
+		}
+		counts[labels]++
+	}
+
+	p.family("sockets_listening", prometheusGauge, "Listening sockets, with their process.")
+	for _, l := range order {
+		p.sample(float64(counts[l]), "protocol", l.protocol, "address", l.address, "port", l.port,
+			"process", l.process)
+	}
+
+	p.family("sockets_peer_connections", prometheusGauge, "TCP connections of the busiest remote peers.")
+	for _, peer := range s.Peers {
+		p.sample(float64(peer.Connections), "address", peer.Address)
+	}
+
+	return p.Bytes(), nil
+}
+
+func (s *Sockets) String() string {
+	str := "\t========== SOCKETS ==========\n\n"
+
+	str += "TCP:"
+	for _, state := range tcpStates {
+		if s.States[state] > 0 {
+			str += fmt.Sprintf(" %s %d", state, s.States[state])
+		}
+	}
+	str += fmt.Sprintf("\nUDP: %d\tUnix: %d\n", s.UDP, s.Unix)
+
+	str += "\nProto\tAddress\t\t\tPort\tPID\tProcess\n"
+	for _, l := range s.Listening {
+		pid := "-"
+		if l.Pid != 0 {
+			pid = strconv.Itoa(l.Pid)
+		}
+
+		str += fmt.Sprintf("%s\t%-24s\t%d\t%s\t%s\n", l.Protocol, l.Address, l.Port, pid, l.Process)
+	}
+
+	if len(s.Peers) > 0 {
+		str += "\nPeer\t\t\t\tConnections\n"
+		for _, peer := range s.Peers {
+			str += fmt.Sprintf("%-24s\t%d\n", peer.Address, peer.Connections)
+		}
+	}
+
+	return strings.TrimRight(str, "\n")
+}
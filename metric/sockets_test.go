@@ -0,0 +1,47 @@
+package metric
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestParseSocketAddress(t *testing.T) {
+	tests := []struct {
+		little, big string // Adresse écrite par un hôte petit-boutiste, gros-boutiste
+		ip          string
+		port        int
+	}{
+		{"0100007F:0050", "7F000001:0050", "127.0.0.1", 80},
+		{"00000000:1F90", "00000000:1F90", "0.0.0.0", 8080},
+		{"0A01A8C0:D431", "C0A8010A:D431", "192.168.1.10", 54321},
+		{"00000000000000000000000001000000:0016", "00000000000000000000000000000001:0016", "::1", 22},
+		{"B80D0120000000000000000001000000:01BB", "20010DB8000000000000000000000001:01BB", "2001:db8::1", 443},
+		{"0000000000000000FFFF00000100007F:0035", "00000000000000000000FFFF7F000001:0035", "127.0.0.1", 53},
+	}
+
+	for _, test := range tests {
+		str := test.little
+		if nativeEndian == binary.BigEndian {
+			str = test.big
+		}
+
+		addr, err := parseSocketAddress(str)
+		if err != nil {
+			t.Errorf("parseSocketAddress(%q) failed: %s", str, err)
+			continue
+		}
+
+		if !addr.IP.Equal(net.ParseIP(test.ip)) || addr.Port != test.port {
+			t.Errorf("parseSocketAddress(%q) = %s, want %s port %d", str, addr, test.ip, test.port)
+		}
+	}
+}
+
+func TestParseSocketAddressInvalid(t *testing.T) {
+	for _, str := range []string{"", "0100007F", "0100:0050", "0100007G:0050", "0100007F:", "0100007F:10000"} {
+		if addr, err := parseSocketAddress(str); err == nil {
+			t.Errorf("parseSocketAddress(%q) = %s, want an error", str, addr)
+		}
+	}
+}
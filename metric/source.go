@@ -0,0 +1,98 @@
+package metric
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+)
+
+// source gives the collectors access to the files they read, to the
+// filesystems statistics and to the time of the measures. The live source
+// reads the system; the Recorder and the Replayer replace it to archive or
+// to play back the measures.
+type source interface {
+	Open(name string) (io.ReadCloser, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Statfs(path string, stat *syscall.Statfs_t) error
+	Now() time.Time
+}
+
+type liveSource struct{}
+
+func (liveSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (liveSource) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (liveSource) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (liveSource) Statfs(path string, stat *syscall.Statfs_t) error {
+	return syscall.Statfs(path, stat)
+}
+
+// Now returns the current time, which carries the monotonic clock: the time
+// elapsed between two measures does not depend on the changes of the wall
+// clock (NTP, DST, ...).
+func (liveSource) Now() time.Time {
+	return time.Now()
+}
+
+func (c *Config) getSource() source {
+	if c.source == nil {
+		return liveSource{}
+	}
+
+	return c.source
+}
+
+func (c *Config) open(name string) (io.ReadCloser, error) {
+	return c.getSource().Open(name)
+}
+
+func (c *Config) readFile(name string) ([]byte, error) {
+	file, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+func (c *Config) readDir(name string) ([]os.FileInfo, error) {
+	return c.getSource().ReadDir(name)
+}
+
+func (c *Config) readlink(name string) (string, error) {
+	return c.getSource().Readlink(name)
+}
+
+func (c *Config) statfs(path string, stat *syscall.Statfs_t) error {
+	return c.getSource().Statfs(path, stat)
+}
+
+// now returns the time of the measure being taken.
+func (c *Config) now() time.Time {
+	return c.getSource().Now()
+}
+
+// fileUid returns the owner of a file listed by readDir.
+func fileUid(info os.FileInfo) (uint32, bool) {
+	switch sys := info.Sys().(type) {
+	case *syscall.Stat_t:
+		return sys.Uid, true
+	case *tar.Header:
+		return uint32(sys.Uid), true
+	}
+
+	return 0, false
+}
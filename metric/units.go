@@ -0,0 +1,124 @@
+package metric
+
+import (
+	"fmt"
+	"math"
+)
+
+// rateUnit is the unit of the throughputs: bytes or bits per second, with
+// decimal (SI, 1000) or binary (IEC, 1024) prefixes.
+type rateUnit struct {
+	bits, iec bool
+}
+
+var (
+	siPrefixes  = []string{"", "k", "M", "G", "T", "P"}
+	iecPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi"}
+)
+
+func (c *Config) rateUnit() rateUnit {
+	return rateUnit{bits: c.Bits, iec: c.IEC}
+}
+
+func (u rateUnit) base() float64 {
+	if u.iec {
+		return 1024
+	}
+
+	return 1000
+}
+
+func (u rateUnit) symbol() string {
+	if u.bits {
+		return "b"
+	}
+
+	return "B"
+}
+
+func (u rateUnit) prefixes() []string {
+	if u.iec {
+		return iecPrefixes
+	}
+
+	return siPrefixes
+}
+
+// value converts bytes to the unit, without prefix.
+func (u rateUnit) value(bytes float64) float64 {
+	if u.bits {
+		return bytes * 8
+	}
+
+	return bytes
+}
+
+// mega converts bytes per second to mega units per second: MB/s, MiB/s,
+// Mb/s or Mib/s.
+func (u rateUnit) mega(bytesPerSecond float64) float64 {
+	return u.value(bytesPerSecond) / (u.base() * u.base())
+}
+
+// megaName is the name of the mega unit in the CSV columns: MBps, MiBps,
+// Mbps or Mibps.
+func (u rateUnit) megaName() string {
+	return u.prefixes()[2] + u.symbol() + "ps"
+}
+
+// format formats a throughput with the most readable prefix: 12.3 kB/s,
+// 1.50 GiB/s, ...
+func (u rateUnit) format(bytesPerSecond float64) string {
+	value := u.value(bytesPerSecond)
+	prefixes := u.prefixes()
+
+	i := 0
+	for math.Abs(value) >= u.base() && i < len(prefixes)-1 {
+		value /= u.base()
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s/s", value, u.symbol())
+	}
+
+	return fmt.Sprintf("%.2f %s%s/s", value, prefixes[i], u.symbol())
+}
+
+// FormatRate formats a throughput in bytes per second with the unit selected
+// by -bits and -iec.
+func (c *Config) FormatRate(bytesPerSecond float64) string {
+	return c.rateUnit().format(bytesPerSecond)
+}
+
+// RateUnit returns the mega unit of the throughputs, MB/s by default.
+func (c *Config) RateUnit() string {
+	u := c.rateUnit()
+
+	return u.prefixes()[2] + u.symbol() + "/s"
+}
+
+// Largeurs des compteurs du noyau
+const (
+	counter32 = 32
+	counter64 = 64
+)
+
+// counterDelta returns the increase of a counter of the given width, in bits,
+// between two measures. A 32 bits counter lower than before either wrapped
+// around, which is assumed when the increase it implies is plausible, or was
+// reset (interface recreated, device reattached, ...). A 64 bits counter
+// lower than before was reset. ok is false for a reset.
+func counterDelta(last, current uint64, bits uint) (delta uint64, ok bool) {
+	if current >= last {
+		return current - last, true
+	}
+
+	if bits == counter32 && last <= math.MaxUint32 {
+		delta = current + (math.MaxUint32 + 1 - last)
+		if delta < 1<<31 {
+			return delta, true
+		}
+	}
+
+	return 0, false
+}
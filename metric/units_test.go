@@ -0,0 +1,32 @@
+package metric
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name          string
+		last, current uint64
+		bits          uint
+		delta         uint64
+		ok            bool
+	}{
+		{"increase", 100, 150, counter64, 50, true},
+		{"unchanged", 100, 100, counter32, 0, true},
+		{"32 bits wrap", math.MaxUint32 - 9, 20, counter32, 30, true},
+		{"32 bits reset", 1000000000, 10, counter32, 0, false},
+		{"32 bits counter above 32 bits", math.MaxUint32 + 10, 20, counter32, 0, false},
+		{"64 bits reset", math.MaxUint32 - 9, 20, counter64, 0, false},
+		{"64 bits reset to zero", 1 << 40, 0, counter64, 0, false},
+	}
+
+	for _, test := range tests {
+		delta, ok := counterDelta(test.last, test.current, test.bits)
+		if delta != test.delta || ok != test.ok {
+			t.Errorf("%s: counterDelta(%d, %d, %d) = %d, %v, want %d, %v", test.name,
+				test.last, test.current, test.bits, delta, ok, test.delta, test.ok)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	vmstat           = "vmstat"
+	vmstatOutputFile = "vmstat"
+)
+
+// Vmstat is the paging activity of the kernel from /proc/vmstat: paging,
+// swapping, faults, reclaim, compaction and transparent huge pages. Memory
+// pressure shows up there long before the free memory runs out.
+type Vmstat struct {
+	saver
+	config                 *Config
+	counters, lastCounters map[string]uint64
+	time, lastTime         time.Time
+	Rates                  map[string]float64 // Par seconde
+}
+
+// vmstatCounter is a counter of /proc/vmstat whose rate is reported. Older
+// kernels split some of them per zone (allocstall_normal, pgscan_kswapd_dma32,
+// ...): they are summed.
+type vmstatCounter struct {
+	name, description string
+}
+
+var vmstatCounters = []vmstatCounter{
+	{"pgpgin", "KiB paged in"},
+	{"pgpgout", "KiB paged out"},
+	{"pswpin", "pages swapped in"},
+	{"pswpout", "pages swapped out"},
+	{"pgfault", "page faults"},
+	{"pgmajfault", "major page faults"},
+	{"pgscan_kswapd", "pages scanned by kswapd"},
+	{"pgscan_direct", "pages scanned by direct reclaim"},
+	{"pgsteal_kswapd", "pages reclaimed by kswapd"},
+	{"pgsteal_direct", "pages reclaimed by direct reclaim"},
+	{"allocstall", "direct reclaim stalls"},
+	{"compact_stall", "compaction stalls"},
+	{"compact_fail", "compaction failures"},
+	{"thp_fault_alloc", "huge pages allocated on fault"},
+	{"thp_fault_fallback", "huge page allocations failed on fault"},
+	{"thp_collapse_alloc", "huge pages collapsed by khugepaged"},
+	{"oom_kill", "processes killed by the OOM killer"},
+}
+
+func init() {
+	mustRegister("vmstat", "Paging, swapping, reclaim and OOM kills rates (/proc/vmstat)", false,
+		func(config *Config) (Metric, error) { return NewVmstat(config) })
+}
+
+func NewVmstat(config *Config) (*Vmstat, error) {
+	vmstat := &Vmstat{}
+
+	saver, err := newSaver(config, vmstat, vmstatOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	vmstat.saver = *saver
+	vmstat.config = config
+	vmstat.counters = make(map[string]uint64)
+	vmstat.Rates = make(map[string]float64)
+
+	return vmstat, nil
+}
+
+func (v *Vmstat) Update() error {
+	v.lastCounters, v.lastTime = v.counters, v.time
+	v.counters = make(map[string]uint64)
+	v.time = v.config.now()
+
+	fileName := v.config.procPath(vmstat)
+
+	b, err := v.config.readFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]uint64)
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %s", fileName, fields[0], err)
+		}
+
+		values[fields[0]] = value
+	}
+
+	for _, c := range vmstatCounters {
+		if value, ok := c.value(values); ok {
+			v.counters[c.name] = value
+		}
+	}
+
+	v.computeRates()
+
+	return nil
+}
+
+// value returns the value of c in the counters of /proc/vmstat, summing the
+// per zone ones when the kernel does not expose the total: allocstall is
+// allocstall_normal + allocstall_movable + ...
+func (c vmstatCounter) value(values map[string]uint64) (uint64, bool) {
+	if value, ok := values[c.name]; ok {
+		return value, true
+	}
+
+	var sum uint64
+	found := false
+
+	for _, zone := range []string{"dma", "dma32", "normal", "movable", "device", "high"} {
+		if value, ok := values[c.name+"_"+zone]; ok {
+			sum += value
+			found = true
+		}
+	}
+
+	return sum, found
+}
+
+// computeRates computes the rate of the reported counters. A counter missing
+// from one of the measures (older kernel) or reset has no rate.
+func (v *Vmstat) computeRates() {
+	v.Rates = make(map[string]float64)
+
+	if v.lastTime.IsZero() {
+		return
+	}
+
+	seconds := v.time.Sub(v.lastTime).Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	for _, c := range v.reported() {
+		last, ok := v.lastCounters[c.name]
+		if !ok {
+			continue
+		}
+
+		if delta, ok := counterDelta(last, v.counters[c.name], counter64); ok {
+			v.Rates[c.name] = float64(delta) / seconds
+		}
+	}
+}
+
+// reported returns the reported counters the kernel exposes.
+func (v *Vmstat) reported() []vmstatCounter {
+	var counters []vmstatCounter
+
+	for _, c := range vmstatCounters {
+		if _, ok := v.counters[c.name]; ok {
+			counters = append(counters, c)
+		}
+	}
+
+	return counters
+}
+
+func (v *Vmstat) CSVHeader() []string {
+	var header []string
+
+	for _, c := range v.reported() {
+		header = append(header, c.name+"_per_s")
+	}
+
+	return header
+}
+
+func (v *Vmstat) MarshalCSV() ([]byte, error) {
+	var values []string
+
+	for _, c := range v.reported() {
+		values = append(values, fmt.Sprintf("%.2f", v.Rates[c.name]))
+	}
+
+	return []byte(strings.Join(values, CSVSeparator) + "\n"), nil
+}
+
+func (v *Vmstat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"rates":    v.Rates,
+		"counters": v.counters,
+	})
+}
+
+func (v *Vmstat) MarshalPrometheus() ([]byte, error) {
+	var p prometheusBuffer
+
+	p.family("vmstat_total", prometheusCounter, "Counters of /proc/vmstat.")
+	for _, c := range v.reported() {
+		p.sample(float64(v.counters[c.name]), "counter", c.name)
+	}
+
+	return p.Bytes(), nil
+}
+
+func (v *Vmstat) String() string {
+	str := "\t========== VMSTAT ==========\n\n"
+
+	for _, c := range v.reported() {
+		str += fmt.Sprintf("%-20s\t%10.2f /s\t%s\n", c.name, v.Rates[c.name], c.description)
+	}
+
+	return strings.TrimRight(str, "\n")
+}
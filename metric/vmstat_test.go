@@ -0,0 +1,27 @@
+package metric
+
+import "testing"
+
+func TestVmstatCounterValue(t *testing.T) {
+	tests := []struct {
+		counter string
+		values  map[string]uint64
+		value   uint64
+		ok      bool
+	}{
+		{"allocstall", map[string]uint64{"allocstall": 7, "allocstall_normal": 3}, 7, true},
+		{"allocstall", map[string]uint64{"allocstall_dma": 1, "allocstall_normal": 3, "allocstall_movable": 2}, 6, true},
+		{"pgscan_kswapd", map[string]uint64{"pgscan_kswapd_dma32": 10, "pgscan_kswapd_normal": 90}, 100, true},
+		// pgscan_direct_throttle n'est pas une zone de pgscan_direct
+		{"pgscan_direct", map[string]uint64{"pgscan_direct_throttle": 5, "pgscan_direct_normal": 20}, 20, true},
+		{"pgscan_direct", map[string]uint64{"pgscan_direct_throttle": 5}, 0, false},
+		{"oom_kill", map[string]uint64{"pgfault": 1}, 0, false},
+	}
+
+	for _, test := range tests {
+		value, ok := vmstatCounter{name: test.counter}.value(test.values)
+		if value != test.value || ok != test.ok {
+			t.Errorf("%s.value(%v) = %d, %v, want %d, %v", test.counter, test.values, value, ok, test.value, test.ok)
+		}
+	}
+}
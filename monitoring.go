@@ -2,28 +2,28 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kukinsula/monitoring/metric"
 )
 
-var (
-	supportedMetrics    = []string{"cpu", "mem", "net"}
-	nbSupprortedMetrics = len(supportedMetrics)
-)
-
 type Monitoring struct {
-	config  *metric.Config
-	metrics []metric.Metric
+	sync.RWMutex
+	config   *metric.Config
+	metrics  []metric.Metric
+	names    []string
+	interval time.Duration
+	recorder *metric.Recorder
+	replayer *metric.Replayer
 }
 
 func NewMonitoring(config *metric.Config) (*Monitoring, error) {
-	var fields []string
-
 	// Création du dossier de output
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
@@ -38,76 +38,186 @@ func NewMonitoring(config *metric.Config) (*Monitoring, error) {
 		return nil, err
 	}
 
-	if config.Metrics == "" {
-		// TODO : trouver mieux
-		fields = supportedMetrics
-	} else {
-		fields = strings.Split(config.Metrics, ",")
+	monitoring := &Monitoring{
+		config:   config,
+		names:    config.MetricNames,
+		interval: config.Sleep,
+	}
 
-		if len(fields) > nbSupprortedMetrics {
-			return nil, fmt.Errorf("too much metrics: max is %d", nbSupprortedMetrics)
+	// Les collecteurs lisent au travers de l'enregistreur ou du rejoueur :
+	// ils doivent être créés avant eux
+	if config.Record != "" {
+		monitoring.recorder, err = metric.NewRecorder(config, config.Record)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	metrics := make([]metric.Metric, 0, nbSupprortedMetrics)
-
-	var m metric.Metric
+	if config.Replay != "" {
+		monitoring.replayer, err = metric.NewReplayer(config, config.Replay)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for _, field := range fields {
-		switch field {
-		case "cpu":
-			m, err = metric.NewCPU(config)
-		case "mem":
-			m, err = metric.NewMemory(config)
-		case "net":
-			m, err = metric.NewNetwork(config)
-		case "proc":
-			// m, err = metric.NewProcesses(config)
-		default:
-			err = fmt.Errorf("invalid metric '%s'", field)
+	for _, name := range monitoring.names {
+		collector, ok := metric.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid metric '%s'", name)
 		}
 
+		m, err := collector.New(config)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("metric '%s': %s", name, err)
 		}
 
-		metrics = append(metrics, m)
+		monitoring.metrics = append(monitoring.metrics, m)
 	}
 
-	return &Monitoring{config, metrics}, nil
+	return monitoring, nil
 }
 
 func (m *Monitoring) Start() (err error) {
-	clear()
+	serverErrors := make(chan error, 2)
+
+	if m.config.Mode == metric.ModeWEB {
+		go func() {
+			serverErrors <- newWebServer(m).ListenAndServe()
+		}()
+	}
+
+	if m.config.PrometheusServer != "" {
+		go func() {
+			serverErrors <- newPrometheusServer(m).ListenAndServe()
+		}()
+	}
+
+	ui, err := newTUI(m)
+	if err != nil {
+		return err
+	}
+	defer ui.Close()
+
+	// Fermeture propre sur SIGINT et SIGTERM : Start rend la main et les
+	// fichiers sont fermés par Close
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var deadline <-chan time.Time
+	if m.config.Duration > 0 {
+		deadline = time.After(m.config.Duration)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
-		for _, metric := range m.metrics {
-			err = metric.Update()
-			if err != nil {
-				return fmt.Errorf("metric update failed: %s", err)
+		select {
+		case <-signals:
+			return nil
+
+		case <-deadline:
+			return nil
+
+		case err = <-serverErrors:
+			return fmt.Errorf("server failed: %s", err)
+
+		case key := <-ui.keys:
+			if !ui.handleKey(key) {
+				return nil
+			}
+
+			ui.draw()
+
+		case <-timer.C:
+			tick := time.Now()
+
+			if m.replayer != nil {
+				err = m.replayer.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("replay failed: %s", err)
+				}
 			}
 
-			err = metric.Save()
+			err = m.update()
 			if err != nil {
-				return fmt.Errorf("metric save failed: %s", err)
+				return err
 			}
 
-			fmt.Printf("%s\n", metric)
-		}
+			for _, metric := range m.metrics {
+				err = metric.Save()
+				if err != nil {
+					return fmt.Errorf("metric save failed: %s", err)
+				}
+			}
 
-		time.Sleep(time.Second)
-		clear()
+			ui.push()
+			ui.draw()
+			timer.Reset(m.nextInterval() - time.Since(tick))
+		}
 	}
 }
 
-func (m *Monitoring) Close() {
+// update updates every metric while holding the write lock, so that the web
+// server never reads a metric in the middle of an update.
+func (m *Monitoring) update() error {
+	m.Lock()
+	defer m.Unlock()
+
+	start := time.Now()
+
 	for _, metric := range m.metrics {
-		metric.Close()
+		err := metric.Update()
+		if err != nil {
+			return fmt.Errorf("metric update failed: %s", err)
+		}
+	}
+
+	if m.recorder != nil {
+		err := m.recorder.Snapshot(start)
+		if err != nil {
+			return fmt.Errorf("record failed: %s", err)
+		}
 	}
+
+	return nil
 }
 
-func clear() {
-	cmd := exec.Command("clear")
-	cmd.Stdout = os.Stdout
-	cmd.Run()
+// nextInterval returns the time between the current update and the next
+// one: the refresh interval, or the recorded one when replaying. The time
+// spent updating and saving is deducted from it, so that the measures do
+// not drift.
+func (m *Monitoring) nextInterval() time.Duration {
+	if m.replayer != nil {
+		return m.replayer.Delay(m.config.ReplaySpeed)
+	}
+
+	return m.interval
+}
+
+// Close closes every metric and returns the first error.
+func (m *Monitoring) Close() (err error) {
+	for _, metric := range m.metrics {
+		e := metric.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if m.recorder != nil {
+		e := m.recorder.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if m.replayer != nil {
+		m.replayer.Close()
+	}
+
+	return err
 }
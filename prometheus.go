@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+const prometheusPath = "/metrics"
+
+type prometheusMarshaler interface {
+	MarshalPrometheus() ([]byte, error)
+}
+
+// newPrometheusServer returns the HTTP server serving only the /metrics
+// endpoint, for the -prometheus-address option.
+func newPrometheusServer(m *Monitoring) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(prometheusPath, m.servePrometheus)
+
+	return &http.Server{
+		Addr:    m.config.PrometheusServer,
+		Handler: mux,
+	}
+}
+
+// servePrometheus serves the last measures of every metric in the Prometheus
+// text exposition format.
+func (m *Monitoring) servePrometheus(w http.ResponseWriter, r *http.Request) {
+	b, err := m.marshalPrometheus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b)
+}
+
+// marshalPrometheus marshals the last measures of every metric under the
+// read lock. The deferred unlock releases it even if a marshaler panics.
+func (m *Monitoring) marshalPrometheus() ([]byte, error) {
+	var buf bytes.Buffer
+
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, metric := range m.metrics {
+		marshaler, ok := metric.(prometheusMarshaler)
+		if !ok {
+			continue
+		}
+
+		b, err := marshaler.MarshalPrometheus()
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(b)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the struct winsize of the TIOCGWINSZ ioctl.
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+
+	return ioctl(fd, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
+
+// makeRaw disables the line buffering, the echo and the signal characters of
+// a terminal so that every key is read as soon as it is pressed. It returns
+// the previous state, to be given to restoreTerminal.
+func makeRaw(fd int) (*syscall.Termios, error) {
+	var state syscall.Termios
+
+	err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&state))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := state
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	err = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func restoreTerminal(fd int, state *syscall.Termios) error {
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(state))
+}
+
+// terminalSize returns the number of columns and rows of a terminal.
+func terminalSize(fd int) (width, height int, err error) {
+	var ws winsize
+
+	err = ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kukinsula/monitoring/metric"
+)
+
+const (
+	minInterval    = 100 * time.Millisecond
+	maxInterval    = time.Minute
+	historyLength  = 256
+	defaultWidth   = 80
+	defaultHeight  = 24
+	keyCtrlC       = 3
+	keysPerPage    = 9
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	colorReset     = "\x1b[0m"
+	colorBold      = "\x1b[1m"
+	colorReverse   = "\x1b[7m"
+	colorGreen     = "\x1b[32m"
+	colorYellow    = "\x1b[33m"
+	colorRed       = "\x1b[31m"
+	colorCyan      = "\x1b[36m"
+)
+
+var (
+	sparks       = []rune("▁▂▃▄▅▆▇█")
+	processSorts = []string{metric.SortByCPU, metric.SortByMemory, metric.SortByPid}
+)
+
+// tui is the full-screen terminal view of the monitoring. When the standard
+// output is not a terminal it falls back to printing every metric.
+type tui struct {
+	monitoring  *Monitoring
+	interactive bool
+	paused      bool
+	hidden      []bool
+	page        int // Page du pied de page : les touches 1-9 y basculent les metrics
+	processSort string
+	history     map[string][]float64
+	keys        chan byte
+	state       *syscall.Termios
+}
+
+func newTUI(m *Monitoring) (*tui, error) {
+	t := &tui{
+		monitoring:  m,
+		interactive: isTerminal(syscall.Stdout),
+		hidden:      make([]bool, len(m.metrics)),
+		processSort: m.config.ProcessSort,
+		history:     make(map[string][]float64),
+	}
+
+	if !t.interactive {
+		return t, nil
+	}
+
+	if isTerminal(syscall.Stdin) {
+		state, err := makeRaw(syscall.Stdin)
+		if err != nil {
+			return nil, err
+		}
+
+		t.state = state
+		t.keys = make(chan byte)
+
+		go t.readKeys()
+	}
+
+	fmt.Print(enterAltScreen)
+
+	return t, nil
+}
+
+func (t *tui) Close() {
+	if !t.interactive {
+		return
+	}
+
+	fmt.Print(leaveAltScreen)
+
+	if t.state != nil {
+		restoreTerminal(syscall.Stdin, t.state)
+	}
+}
+
+func (t *tui) readKeys() {
+	b := make([]byte, 16)
+
+	for {
+		n, err := os.Stdin.Read(b)
+		if err != nil {
+			return
+		}
+
+		for _, key := range b[:n] {
+			t.keys <- key
+		}
+	}
+}
+
+// handleKey applies a key press. It returns false when the user quits.
+func (t *tui) handleKey(key byte) bool {
+	m := t.monitoring
+
+	switch key {
+	case 'q', 'Q', keyCtrlC:
+		return false
+
+	case 'p', 'P', ' ':
+		t.paused = !t.paused
+
+	// Le serveur web lit l'intervalle sous le verrou
+	case '+':
+		m.Lock()
+		m.interval *= 2
+		if m.interval > maxInterval {
+			m.interval = maxInterval
+		}
+		m.Unlock()
+
+	case '-':
+		m.Lock()
+		m.interval /= 2
+		if m.interval < minInterval {
+			m.interval = minInterval
+		}
+		m.Unlock()
+
+	case 's', 'S':
+		for i, sort := range processSorts {
+			if sort == t.processSort {
+				t.processSort = processSorts[(i+1)%len(processSorts)]
+				break
+			}
+		}
+
+		m.Lock()
+		for _, v := range m.metrics {
+			if processes, ok := v.(*metric.Processes); ok {
+				processes.SortBy(t.processSort)
+			}
+		}
+		m.Unlock()
+
+	case '\t':
+		if t.pages() > 0 {
+			t.page = (t.page + 1) % t.pages()
+		}
+
+	default:
+		if i := t.page*keysPerPage + int(key-'1'); key >= '1' && key <= '9' && i < len(t.hidden) {
+			t.hidden[i] = !t.hidden[i]
+		}
+	}
+
+	return true
+}
+
+// push records the values drawn as sparklines after every update.
+func (t *tui) push() {
+	for _, v := range t.monitoring.metrics {
+		if network, ok := v.(*metric.Network); ok {
+			for _, i := range network.Interfaces() {
+				t.record(i.Name+" down", i.Download)
+				t.record(i.Name+" up", i.Upload)
+			}
+		}
+	}
+}
+
+func (t *tui) record(name string, value float64) {
+	values := append(t.history[name], value)
+	if len(values) > historyLength {
+		values = values[len(values)-historyLength:]
+	}
+
+	t.history[name] = values
+}
+
+// draw redraws the whole screen.
+func (t *tui) draw() {
+	if !t.interactive {
+		for _, v := range t.monitoring.metrics {
+			fmt.Printf("%s\n", v)
+		}
+		return
+	}
+
+	if t.paused {
+		t.drawStatus()
+		return
+	}
+
+	width, height, err := terminalSize(syscall.Stdout)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = defaultWidth, defaultHeight
+	}
+
+	panes := make([][]string, len(t.monitoring.metrics))
+	used := 2 // En-tête et pied de page
+	processPane := -1
+
+	for i, v := range t.monitoring.metrics {
+		if t.hidden[i] {
+			continue
+		}
+
+		if _, ok := v.(*metric.Processes); ok {
+			processPane = i
+			continue
+		}
+
+		panes[i] = t.drawPane(v, width)
+		used += len(panes[i])
+	}
+
+	if processPane >= 0 {
+		processes := t.monitoring.metrics[processPane].(*metric.Processes)
+		panes[processPane] = t.drawProcesses(processes, width, height-used)
+	}
+
+	lines := []string{t.header()}
+	for _, pane := range panes {
+		lines = append(lines, pane...)
+	}
+
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("\x1b[H")
+	for _, line := range lines {
+		buf.WriteString(fit(line, width))
+		buf.WriteString(colorReset + "\x1b[K\n")
+	}
+	buf.WriteString("\x1b[J")
+	buf.WriteString(fmt.Sprintf("\x1b[%d;1H", height))
+	buf.WriteString(fit(t.footer(), width) + colorReset + "\x1b[K")
+
+	os.Stdout.Write(buf.Bytes())
+}
+
+// drawStatus only redraws the header, to show the pause and the interval.
+func (t *tui) drawStatus() {
+	width, _, err := terminalSize(syscall.Stdout)
+	if err != nil || width <= 0 {
+		width = defaultWidth
+	}
+
+	fmt.Printf("\x1b[H%s%s\x1b[K", fit(t.header(), width), colorReset)
+}
+
+func (t *tui) header() string {
+	status := ""
+	if t.paused {
+		status = "  " + colorReverse + " PAUSED " + colorReset
+	}
+
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s%s%s - %s - every %s%s", colorBold, hostname, colorReset,
+		time.Now().Format("15:04:05"), t.monitoring.interval, status)
+}
+
+func (t *tui) footer() string {
+	str := colorReverse + " q" + colorReset + " quit " +
+		colorReverse + " p" + colorReset + " pause " +
+		colorReverse + " +/-" + colorReset + " interval " +
+		colorReverse + " s" + colorReset + " sort "
+
+	if t.pages() > 1 {
+		str += colorReverse + " tab" + colorReset + fmt.Sprintf(" page %d/%d ", t.page+1, t.pages())
+	}
+
+	names := t.monitoring.names
+	for i := t.page * keysPerPage; i < len(names) && i < (t.page+1)*keysPerPage; i++ {
+		key := i%keysPerPage + 1
+
+		if t.hidden[i] {
+			str += fmt.Sprintf("%s %d%s %s ", colorReverse, key, colorReset, names[i])
+		} else {
+			str += fmt.Sprintf("%s %d%s %s%s%s ", colorReverse, key, colorReset,
+				colorCyan, names[i], colorReset)
+		}
+	}
+
+	return str
+}
+
+// pages returns the number of pages of the footer, keysPerPage metrics each.
+func (t *tui) pages() int {
+	return (len(t.monitoring.names) + keysPerPage - 1) / keysPerPage
+}
+
+func (t *tui) drawPane(v metric.Metric, width int) []string {
+	switch m := v.(type) {
+	case *metric.CPU:
+		return t.drawCPU(m, width)
+	case *metric.Memory:
+		return t.drawMemory(m, width)
+	case *metric.Vmstat:
+		return t.drawVmstat(m, width)
+	case *metric.Network:
+		return t.drawNetwork(m, width)
+	case *metric.PSI:
+		return t.drawPSI(m, width)
+	case *metric.Sensors:
+		return t.drawSensors(m, width)
+	}
+
+	lines := strings.Split(strings.TrimRight(expandTabs(fmt.Sprint(v)), "\n"), "\n")
+	lines[0] = title(strings.Trim(strings.TrimSpace(lines[0]), "= "))
+
+	return append(lines, "")
+}
+
+func (t *tui) drawCPU(cpu *metric.CPU, width int) []string {
+	lines := []string{
+		title("CPU"),
+		"all   " + bar(cpu.LoadAverage, width-6),
+		"      " + fit(cpu.Times.String(), width-6),
+	}
+
+	// Les coeurs sont répartis sur plusieurs colonnes
+	columns := width / 40
+	if columns > len(cpu.LoadAverages) {
+		columns = len(cpu.LoadAverages)
+	}
+	if columns < 1 {
+		columns = 1
+	}
+	columnWidth := width / columns
+
+	line := ""
+	for i, load := range cpu.LoadAverages {
+		line += fmt.Sprintf("%-6s", fmt.Sprintf("cpu%d", cpu.CoreID(i))) + bar(load, columnWidth-7) + " "
+
+		if (i+1)%columns == 0 || i == len(cpu.LoadAverages)-1 {
+			lines = append(lines, line)
+			line = ""
+		}
+	}
+
+	return append(lines, "")
+}
+
+func (t *tui) drawMemory(mem *metric.Memory, width int) []string {
+	return []string{
+		title("MEMORY"),
+		"Mem   " + bar(mem.PercentMemUsed(), width-6),
+		"Cache " + bar(mem.PercentMemBuffCache(), width-6),
+		"Swap  " + bar(mem.PercentSwapOccupied(), width-6),
+		"",
+	}
+}
+
+// drawVmstat draws the paging, the reclaim and the OOM kills, the direct
+// reclaim stalls in yellow and the OOM kills in red.
+func (t *tui) drawVmstat(vmstat *metric.Vmstat, width int) []string {
+	rate := vmstat.Rates
+
+	stalls := fmt.Sprintf("stalls %4.0f/s", rate["allocstall"])
+	if rate["allocstall"] > 0 {
+		stalls = colorYellow + stalls + colorReset
+	}
+
+	oom := fmt.Sprintf("oom %4.0f/s", rate["oom_kill"])
+	if rate["oom_kill"] > 0 {
+		oom = colorRed + oom + colorReset
+	}
+
+	return []string{
+		title("VMSTAT"),
+		fmt.Sprintf("page in  %10.0f KiB/s  page out %10.0f KiB/s  swap in %8.0f/s  swap out %8.0f/s",
+			rate["pgpgin"], rate["pgpgout"], rate["pswpin"], rate["pswpout"]),
+		fmt.Sprintf("faults   %10.0f/s      major    %10.0f/s      scan    %8.0f/s  steal    %8.0f/s  %s  %s",
+			rate["pgfault"], rate["pgmajfault"],
+			rate["pgscan_kswapd"]+rate["pgscan_direct"], rate["pgsteal_kswapd"]+rate["pgsteal_direct"],
+			stalls, oom),
+		"",
+	}
+}
+
+func (t *tui) drawNetwork(network *metric.Network, width int) []string {
+	lines := []string{title("NETWORK")}
+	config := t.monitoring.config
+	sparkWidth := (width - 54) / 2
+	if sparkWidth < 0 {
+		sparkWidth = 0
+	}
+
+	for _, i := range network.Interfaces() {
+		lines = append(lines, fmt.Sprintf("%-10.10s down %s%s%s %13s  up %s%s%s %13s",
+			i.Name,
+			colorGreen, sparkline(t.history[i.Name+" down"], sparkWidth), colorReset, config.FormatRate(i.Received()),
+			colorCyan, sparkline(t.history[i.Name+" up"], sparkWidth), colorReset, config.FormatRate(i.Transmitted())))
+	}
+
+	return append(lines, "")
+}
+
+func (t *tui) drawPSI(psi *metric.PSI, width int) []string {
+	lines := []string{title("PRESSURE")}
+
+	if !psi.Available() {
+		return append(lines, "unavailable", "")
+	}
+
+	barWidth := (width - 20) / 2
+
+	for _, r := range psi.Resources {
+		if !r.Available {
+			lines = append(lines, fmt.Sprintf("%-7s unavailable", r.Name))
+			continue
+		}
+
+		full := fmt.Sprintf("%*s", barWidth, "-")
+		if r.Full != nil {
+			full = bar(r.Full.Stall, barWidth)
+		}
+
+		lines = append(lines, fmt.Sprintf("%-7s some %s full %s", r.Name, bar(r.Some.Stall, barWidth), full))
+	}
+
+	return append(lines, "")
+}
+
+func (t *tui) drawSensors(sensors *metric.Sensors, width int) []string {
+	lines := []string{title("SENSORS")}
+
+	if len(sensors.Readings) == 0 {
+		return append(lines, "no sensor", "")
+	}
+
+	// Les capteurs sont répartis sur plusieurs colonnes
+	columns := width / 40
+	if columns < 1 {
+		columns = 1
+	}
+	columnWidth := width / columns
+
+	line := ""
+	for i, r := range sensors.Readings {
+		color := ""
+		if r.Critical {
+			color = colorRed
+		} else if r.High {
+			color = colorYellow
+		}
+
+		name := r.Label
+		if name == "" {
+			name = r.Name()
+		}
+
+		precision := 1
+		if r.Kind == metric.SensorVoltage {
+			precision = 2
+		}
+
+		value := fmt.Sprintf("%.*f %s", precision, r.Value, r.Unit())
+		line += fmt.Sprintf("%-*.*s %s%12s%s ", columnWidth-14, columnWidth-14, name, color, value, colorReset)
+
+		if (i+1)%columns == 0 || i == len(sensors.Readings)-1 {
+			lines = append(lines, line)
+			line = ""
+		}
+	}
+
+	return append(lines, "")
+}
+
+func (t *tui) drawProcesses(processes *metric.Processes, width, height int) []string {
+	lines := []string{
+		title(fmt.Sprintf("PROCESSES (%d, sorted by %s)", len(processes.Processes), t.processSort)),
+		colorReverse + fmt.Sprintf("%7s %-10s %s %6s %10s %10s %4s %-*s",
+			"PID", "USER", "S", "%CPU", "RSS", "VIRT", "THR", width, "COMMAND"),
+	}
+
+	n := height - len(lines)
+	if n < 1 {
+		n = 1
+	}
+	if top := t.monitoring.config.ProcessTop; top > 0 && n > top {
+		n = top
+	}
+
+	for _, p := range processes.Top(n) {
+		lines = append(lines, fmt.Sprintf("%7d %-10.10s %s %6.1f %10s %10s %4d %s",
+			p.Pid, p.User, p.State, p.CPU, p.RSS, p.VirtualMem, p.NumThreads, p.Command))
+	}
+
+	return lines
+}
+
+func title(str string) string {
+	return colorBold + colorCyan + str + colorReset
+}
+
+// bar draws a percentage as a gauge of the given width.
+func bar(percent float64, width int) string {
+	if math.IsNaN(percent) {
+		percent = 0
+	}
+
+	label := fmt.Sprintf("%5.1f%%", percent)
+	size := width - len(label) - 3
+	if size < 1 {
+		return label
+	}
+
+	filled := int(percent*float64(size)/100.0 + 0.5)
+	if filled > size {
+		filled = size
+	} else if filled < 0 {
+		filled = 0
+	}
+
+	color := colorGreen
+	if percent >= 80 {
+		color = colorRed
+	} else if percent >= 50 {
+		color = colorYellow
+	}
+
+	return "[" + color + strings.Repeat("|", filled) + colorReset +
+		strings.Repeat(" ", size-filled) + "] " + label
+}
+
+// sparkline draws the last width values, scaled on their maximum.
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	spark := make([]rune, 0, width)
+	for i := len(values); i < width; i++ {
+		spark = append(spark, ' ')
+	}
+
+	for _, v := range values {
+		i := 0
+		if max > 0 && v > 0 {
+			i = int(v / max * float64(len(sparks)-1))
+		}
+		spark = append(spark, sparks[i])
+	}
+
+	return string(spark)
+}
+
+// fit truncates a line to the width of the terminal, ignoring the escape
+// sequences which are not displayed.
+func fit(line string, width int) string {
+	var buf bytes.Buffer
+	visible, escape := 0, false
+
+	for _, r := range line {
+		switch {
+		case escape:
+			escape = r < '@' || r > '~' || r == '['
+		case r == '\x1b':
+			escape = true
+		case visible >= width:
+			continue
+		default:
+			visible++
+		}
+
+		buf.WriteRune(r)
+	}
+
+	return buf.String()
+}
+
+func expandTabs(str string) string {
+	var buf bytes.Buffer
+	column := 0
+
+	for _, r := range str {
+		switch r {
+		case '\t':
+			n := 8 - column%8
+			buf.WriteString(strings.Repeat(" ", n))
+			column += n
+		case '\n':
+			buf.WriteRune(r)
+			column = 0
+		default:
+			buf.WriteRune(r)
+			column++
+		}
+	}
+
+	return buf.String()
+}
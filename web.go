@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const apiPrefix = "/api/"
+
+// newWebServer returns the HTTP server of the web mode. It serves the
+// dashboard on /, the JSON of every enabled metric on /api/<metric> and the
+// Prometheus metrics on /metrics.
+func newWebServer(m *Monitoring) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", m.serveDashboard)
+	mux.HandleFunc(apiPrefix, m.serveAPI)
+	mux.HandleFunc(prometheusPath, m.servePrometheus)
+
+	return &http.Server{
+		Addr:    m.config.WebServer,
+		Handler: mux,
+	}
+}
+
+func (m *Monitoring) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboard)
+}
+
+// serveAPI serves the list of the enabled metrics on /api/ and the last
+// measure of a metric on /api/<metric>.
+func (m *Monitoring) serveAPI(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, apiPrefix)
+
+	b, found, err := m.marshalAPI(name)
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown metric '%s'", name), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
+
+// marshalAPI marshals the list of the enabled metrics, if name is empty, or
+// the last measure of the metric name under the read lock. The deferred
+// unlock releases it even if a marshaler panics.
+func (m *Monitoring) marshalAPI(name string) (b []byte, found bool, err error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if name == "" {
+		b, err = json.Marshal(map[string]interface{}{
+			"interval": m.interval / time.Millisecond,
+			"metrics":  m.names,
+			"unit":     m.config.RateUnit(),
+		})
+
+		return b, true, err
+	}
+
+	for i, metric := range m.metrics {
+		if m.names[i] == name {
+			b, err = json.Marshal(metric)
+			return b, true, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// dashboard is a self-contained page (no external resources) polling the
+// JSON API and drawing the last measures on canvas charts.
+const dashboard = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Monitoring</title>
+<style>
+body { font-family: monospace; background: #1d1f21; color: #c5c8c6; margin: 1em; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin: 0 0 .5em 0; }
+.pane { background: #282a2e; padding: 1em; margin-bottom: 1em; border-radius: 4px; }
+canvas { width: 100%; height: 200px; }
+.legend span { margin-right: 1.5em; }
+pre { white-space: pre-wrap; margin: 0; }
+</style>
+</head>
+<body>
+<h1>Monitoring</h1>
+<div id="panes"></div>
+<script>
+"use strict";
+
+var HISTORY = 120;
+var UNIT = "MB/s"; // Unité des débits, donnée par /api/
+var COLORS = ["#81a2be", "#b5bd68", "#de935f", "#cc6666", "#b294bb",
+	"#8abeb7", "#f0c674", "#a3685a", "#5f819d", "#8c9440"];
+
+// Each extractor turns the JSON of a metric into named series values.
+var extractors = {
+	cpu: function (m) {
+		var s = {"cpu %": m.load};
+		(m.loads || []).forEach(function (v, i) { s["cpu" + i + " %"] = v; });
+		return s;
+	},
+	mem: function (m) {
+		var s = {
+			"mem %": m.total ? m.used * 100 / m.total : 0,
+			"cache %": m.total ? m["buff-cache"] * 100 / m.total : 0
+		};
+		if (m["swap-total"]) {
+			s["swap %"] = m["swap-occupied"] * 100 / m["swap-total"];
+		}
+		return s;
+	},
+	net: function (m) {
+		var s = {};
+		Object.keys(m).sort().forEach(function (name) {
+			s[name + " down " + UNIT] = m[name].download;
+			s[name + " up " + UNIT] = m[name].updaload;
+		});
+		return s;
+	},
+	psi: function (m) {
+		var s = {};
+		Object.keys(m).sort().forEach(function (name) {
+			if (m[name].available) {
+				s[name + " some %"] = m[name].some.stall;
+				if (m[name].full) {
+					s[name + " full %"] = m[name].full.stall;
+				}
+			}
+		});
+		return s;
+	},
+	disk: function (m) {
+		var s = {};
+		Object.keys(m).sort().forEach(function (name) {
+			s[name + " %util"] = m[name].util;
+		});
+		return s;
+	}
+};
+
+function Pane(name) {
+	this.name = name;
+	this.history = {};
+	this.div = document.createElement("div");
+	this.div.className = "pane";
+	this.div.innerHTML = "<h2></h2>";
+	this.div.firstChild.textContent = name;
+	document.getElementById("panes").appendChild(this.div);
+
+	if (extractors[name]) {
+		this.canvas = document.createElement("canvas");
+		this.legend = document.createElement("div");
+		this.legend.className = "legend";
+		this.div.appendChild(this.canvas);
+		this.div.appendChild(this.legend);
+	} else {
+		this.pre = document.createElement("pre");
+		this.div.appendChild(this.pre);
+	}
+}
+
+Pane.prototype.push = function (measure) {
+	if (!this.canvas) {
+		this.pre.textContent = JSON.stringify(measure, null, 2);
+		return;
+	}
+
+	var series = extractors[this.name](measure);
+	var history = this.history;
+
+	Object.keys(series).forEach(function (k) {
+		history[k] = (history[k] || []).concat([series[k] || 0]).slice(-HISTORY);
+	});
+	Object.keys(history).forEach(function (k) {
+		if (!(k in series)) { delete history[k]; }
+	});
+
+	this.draw();
+};
+
+Pane.prototype.draw = function () {
+	var canvas = this.canvas, history = this.history;
+	var ctx = canvas.getContext("2d");
+	var w = canvas.width = canvas.clientWidth;
+	var h = canvas.height = canvas.clientHeight;
+	var keys = Object.keys(history);
+	var max = 0;
+
+	keys.forEach(function (k) {
+		history[k].forEach(function (v) { max = Math.max(max, v); });
+	});
+	max = max > 0 ? max * 1.1 : 1;
+
+	ctx.clearRect(0, 0, w, h);
+	ctx.strokeStyle = "#373b41";
+	ctx.fillStyle = "#969896";
+	for (var i = 0; i <= 4; i++) {
+		var y = Math.round(h - i * h / 4) + 0.5;
+		ctx.beginPath(); ctx.moveTo(0, y); ctx.lineTo(w, y); ctx.stroke();
+		ctx.fillText((max * i / 4).toFixed(2), 2, Math.max(y - 2, 10));
+	}
+
+	this.legend.innerHTML = "";
+	keys.forEach(function (k, n) {
+		var values = history[k], color = COLORS[n % COLORS.length];
+
+		ctx.strokeStyle = color;
+		ctx.beginPath();
+		values.forEach(function (v, i) {
+			var x = w - (values.length - 1 - i) * w / (HISTORY - 1);
+			var y = h - v * h / max;
+			if (i === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+		});
+		ctx.stroke();
+
+		var span = document.createElement("span");
+		span.style.color = color;
+		span.textContent = k + ": " + values[values.length - 1].toFixed(2);
+		this.legend.appendChild(span);
+	}, this);
+};
+
+function get(url, callback) {
+	var xhr = new XMLHttpRequest();
+	xhr.onload = function () {
+		if (xhr.status === 200) { callback(JSON.parse(xhr.responseText)); }
+	};
+	xhr.open("GET", url);
+	xhr.send();
+}
+
+get("/api/", function (api) {
+	UNIT = api.unit;
+
+	var panes = api.metrics.map(function (name) { return new Pane(name); });
+
+	function refresh() {
+		panes.forEach(function (pane) {
+			get("/api/" + pane.name, function (m) { pane.push(m); });
+		});
+	}
+
+	refresh();
+	setInterval(refresh, api.interval);
+});
+</script>
+</body>
+</html>
+`